@@ -0,0 +1,608 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// Environment variable naming the credentials file when --credentials is not given.
+const credentialsEnv = "GOOGLE_CALENDAR_CREDENTIALS"
+
+// Environment variables holding the OAuth client credentials and token JSON
+// themselves, for containers where mounting files is awkward. They are used
+// instead of the files when --credentials and --token are not given.
+const (
+	credentialsJSONEnv = "GOOGLE_CALENDAR_CREDENTIALS_JSON"
+	tokenJSONEnv       = "GOOGLE_CALENDAR_TOKEN_JSON"
+)
+
+// Environment variable naming a service account key file, used when neither
+// --service-account nor OAuth credentials are given.
+const serviceAccountEnv = "GOOGLE_APPLICATION_CREDENTIALS"
+
+// Flags selecting the OAuth credentials and token cache, shared by all commands.
+type authFlags struct {
+	credentials    string
+	token          string
+	profile        profileName
+	noBrowser      bool
+	authCode       string
+	authCodeFile   string
+	serviceAccount string
+	impersonate    string
+	readWrite      bool
+	// writes is set by commands that change calendars. They need read-write
+	// access, and report a cached read-only token instead of replacing it
+	// unless readWrite asks to authorize again.
+	writes bool
+	// viaServiceAccount is set once authorized as a service account, which
+	// has no token to renew.
+	viaServiceAccount bool
+	// tokenFromEnv is set once authorized with the token in tokenJSONEnv,
+	// which cannot be replaced.
+	tokenFromEnv bool
+}
+
+func (a *authFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&a.credentials, "credentials", "credentials.json", "Path to the OAuth client credentials file (or set "+credentialsEnv+", or "+credentialsJSONEnv+" to the JSON itself)")
+	fs.StringVar(&a.token, "token", "", "Path to the cached OAuth token file (default token.json, or the --profile's; or set "+tokenJSONEnv+" to the JSON itself, which is never updated)")
+	fs.Var(&a.profile, "profile", "Name of the account to use, keeping its token and optionally credentials.json in "+profilesDir+"/<profile>")
+	fs.BoolVar(&a.noBrowser, "no-browser", false, "Authorize by pasting the code instead of using a local callback server")
+	fs.StringVar(&a.authCode, "auth-code", "", "Authorization code from visiting the authorization URL, instead of prompting for it")
+	fs.StringVar(&a.authCodeFile, "auth-code-file", "", "File holding the authorization code, instead of prompting for it")
+	fs.StringVar(&a.serviceAccount, "service-account", "", "Path to a service account key file to authorize with instead of OAuth (or set "+serviceAccountEnv+")")
+	fs.StringVar(&a.impersonate, "impersonate", "", "Email of the user a service account with domain-wide delegation acts as")
+	fs.BoolVar(&a.readWrite, "read-write", false, "Request access to change calendars instead of read-only access")
+}
+
+// Returns the cached token file: the --token path, or the token of the
+// --profile, or token.json.
+func (a *authFlags) tokenPath() string {
+	switch {
+	case a.token != "":
+		return a.token
+	case a.profile != "":
+		return profileFile(a.profile, "token.json")
+	}
+	return "token.json"
+}
+
+// Returns the OAuth scope to request.
+func (a *authFlags) scope() string {
+	if a.readWrite || a.writes {
+		return calendar.CalendarScope
+	}
+	return calendar.CalendarReadonlyScope
+}
+
+// Authorizes using the flags parsed by fs and returns a Calendar service.
+// Prompts and progress messages are written to w.
+func (a *authFlags) service(ctx context.Context, fs *flag.FlagSet, w io.Writer) (*calendar.Service, error) {
+	explicit := visited(fs)
+	var client *http.Client
+	var err error
+	if keyPath := a.serviceAccountPath(explicit); keyPath != "" {
+		a.viaServiceAccount = true
+		client, err = serviceAccountClient(ctx, keyPath, a.impersonate, a.scope())
+	} else if a.impersonate != "" {
+		return nil, errors.New("--impersonate requires --service-account")
+	} else {
+		client, err = a.oauthClient(ctx, explicit, w)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	srv, err := calendar.New(client)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve Calendar client: %v", err)
+	}
+	return srv, nil
+}
+
+// Returns the service account key file to authorize with, or "" to use the
+// OAuth flow. The environment is only consulted when no OAuth credentials
+// were chosen explicitly.
+func (a *authFlags) serviceAccountPath(explicit map[string]bool) string {
+	if a.serviceAccount != "" {
+		return a.serviceAccount
+	}
+	if explicit["credentials"] || os.Getenv(credentialsEnv) != "" || os.Getenv(credentialsJSONEnv) != "" {
+		return ""
+	}
+	return os.Getenv(serviceAccountEnv)
+}
+
+// Returns a client authorized as the service account in the key file at
+// path with scope, acting as subject when it is not empty. No token is cached.
+func serviceAccountClient(ctx context.Context, path, subject, scope string) (*http.Client, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve service account path: %v", err)
+	}
+	debugf("reading service account key from %s", path)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read service account key file: %v", err)
+	}
+	config, err := google.JWTConfigFromJSON(b, scope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse service account key file: %v", err)
+	}
+	config.Subject = subject
+	return config.Client(ctx), nil
+}
+
+// Authorizes with the OAuth client credentials and cached token, running the
+// authorization flow when there is no usable token.
+func (a *authFlags) oauthClient(ctx context.Context, explicit map[string]bool, w io.Writer) (*http.Client, error) {
+	b, err := a.credentialsJSON(explicit)
+	if err != nil {
+		return nil, err
+	}
+	config, err := google.ConfigFromJSON(b, a.scope())
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse client secret file to config: %v", err)
+	}
+	tokenPath, err := expandHome(a.tokenPath())
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve token path: %v", err)
+	}
+	var tokenJSON string
+	if !explicit["token"] {
+		tokenJSON = os.Getenv(tokenJSONEnv)
+	}
+	a.tokenFromEnv = tokenJSON != ""
+	if a.writes && !a.readWrite {
+		if err := checkWriteAccess(tokenPath, tokenJSON); err != nil {
+			return nil, err
+		}
+	}
+	flow, err := a.tokenFlow()
+	if err != nil {
+		return nil, err
+	}
+	return getClient(ctx, config, tokenPath, tokenJSON, flow, w)
+}
+
+// Reports an authError when the cached token, from tokenJSON when it is set
+// or else the token file, only grants read-only access. Missing and unusable
+// tokens are left for the authorization flow to replace.
+func checkWriteAccess(tokFile, tokenJSON string) error {
+	var r io.Reader = strings.NewReader(tokenJSON)
+	if tokenJSON == "" {
+		f, err := os.Open(tokFile)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		r = f
+	}
+	cached := cachedToken{Scope: calendar.CalendarReadonlyScope}
+	if err := json.NewDecoder(r).Decode(&cached); err != nil || cached.Scope != calendar.CalendarReadonlyScope {
+		return nil
+	}
+	if tokenJSON != "" {
+		return authError{fmt.Errorf("the token in %s only grants read-only access, replace it with one granting read-write access", tokenJSONEnv)}
+	}
+	return authError{fmt.Errorf("the token in %s only grants read-only access, run again with --read-write to authorize for read-write access", tokFile)}
+}
+
+// tokenFlow chooses how a new token is obtained.
+type tokenFlow struct {
+	// noBrowser pastes the code instead of using a local callback server.
+	noBrowser bool
+	// code, when set, supplies the authorization code without prompting.
+	code io.Reader
+}
+
+// Returns the flow chosen by the flags, with the code given by --auth-code or
+// read from --auth-code-file.
+func (a *authFlags) tokenFlow() (tokenFlow, error) {
+	flow := tokenFlow{noBrowser: a.noBrowser}
+	switch {
+	case a.authCode != "" && a.authCodeFile != "":
+		return flow, errors.New("--auth-code cannot be combined with --auth-code-file")
+	case a.authCode != "":
+		flow.code = strings.NewReader(a.authCode)
+	case a.authCodeFile != "":
+		path, err := expandHome(a.authCodeFile)
+		if err != nil {
+			return flow, fmt.Errorf("unable to resolve authorization code path: %v", err)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return flow, fmt.Errorf("unable to read authorization code file: %v", err)
+		}
+		flow.code = bytes.NewReader(b)
+	}
+	return flow, nil
+}
+
+// Returns the OAuth client credentials JSON, taken from credentialsJSONEnv
+// unless --credentials was given, and read from the credentials file
+// otherwise.
+func (a *authFlags) credentialsJSON(explicit map[string]bool) ([]byte, error) {
+	if env := os.Getenv(credentialsJSONEnv); env != "" && !explicit["credentials"] {
+		debugf("reading credentials from %s", credentialsJSONEnv)
+		return []byte(env), nil
+	}
+	credsPath, err := expandHome(credentialsPath(a.credentials, explicit["credentials"]))
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve credentials path: %v", err)
+	}
+	if a.profile != "" && !explicit["credentials"] && os.Getenv(credentialsEnv) == "" {
+		// A profile may use its own OAuth client, or share the default one.
+		profileCreds, err := expandHome(profileFile(a.profile, "credentials.json"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve credentials path: %v", err)
+		}
+		if _, err := os.Stat(profileCreds); err == nil {
+			credsPath = profileCreds
+		}
+	}
+	debugf("reading credentials from %s", credsPath)
+	b, err := ioutil.ReadFile(credsPath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("credentials file %q not found. Create an OAuth client ID for a desktop app at "+
+			"https://console.cloud.google.com/apis/credentials, download it as JSON, and pass its path "+
+			"with --credentials or %s", credsPath, credentialsEnv)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read client secret file: %v", err)
+	}
+	return b, nil
+}
+
+// Retrieve a token, saves the token, then returns the generated client. When
+// tokenJSON is set it is used instead of the token file, and a token from a
+// new authorization is not saved.
+func getClient(ctx context.Context, config *oauth2.Config, tokFile, tokenJSON string, flow tokenFlow, w io.Writer) (*http.Client, error) {
+	// The token file stores the user's access and refresh tokens, and is
+	// created automatically when the authorization flow completes for the first
+	// time.
+	scope := strings.Join(config.Scopes, " ")
+	source := tokFile
+	var cached *cachedToken
+	var tok *oauth2.Token
+	var err error
+	if tokenJSON != "" {
+		source = tokenJSONEnv
+		cached, err = decodeToken(strings.NewReader(tokenJSON), scope)
+	} else {
+		cached, err = tokenFromFile(tokFile, scope)
+	}
+	if err == nil {
+		debugf("using cached token from %s", source)
+		if tok, err = checkToken(ctx, config, &cached.Token); err != nil && !isStale(err) {
+			return nil, fmt.Errorf("unable to refresh token: %v", err)
+		}
+	}
+	// A refreshed token is saved so that the next run need not refresh it
+	// again, and keeps working when Google rotates the refresh token. It
+	// grants the scope of the token it replaces.
+	if err == nil && tok != &cached.Token && tokenJSON == "" {
+		debugf("saving refreshed token to %s", tokFile)
+		if err := saveToken(tokFile, tok, cached.Scope); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			debugf("no token in %s, authorizing", source)
+		} else {
+			infof("Ignoring unusable token in %s and authorizing again: %v", source, err)
+		}
+		tok, err = getToken(ctx, config, flow, w)
+		if err != nil {
+			return nil, err
+		}
+		if tokenJSON != "" {
+			infof("Not saving the new token, as the token comes from %s", tokenJSONEnv)
+		} else if err := saveToken(tokFile, tok, scope); err != nil {
+			return nil, err
+		}
+	}
+	return config.Client(context.Background(), tok), nil
+}
+
+// errTokenExpired reports a cached token that has expired and cannot be
+// refreshed.
+var errTokenExpired = errors.New("token has expired and has no refresh token")
+
+// Returns tok, refreshed when it has expired. Fails with errTokenExpired or
+// an *oauth2.RetrieveError when the token is no longer usable, like after the
+// refresh token was revoked.
+func checkToken(ctx context.Context, config *oauth2.Config, tok *oauth2.Token) (*oauth2.Token, error) {
+	if tok.Valid() {
+		return tok, nil
+	}
+	if tok.RefreshToken == "" {
+		return nil, errTokenExpired
+	}
+	return config.TokenSource(ctx, tok).Token()
+}
+
+// Reports whether err from checkToken means the token must be replaced, as
+// opposed to a network failure while refreshing it.
+func isStale(err error) bool {
+	if err == errTokenExpired {
+		return true
+	}
+	_, ok := err.(*oauth2.RetrieveError)
+	return ok
+}
+
+// Reports whether a token the API rejected can be replaced by authorizing
+// again, which needs an OAuth token cached in a file.
+func (a *authFlags) renewable() bool {
+	return !a.viaServiceAccount && !a.tokenFromEnv
+}
+
+// Removes the cached token file, if there is one.
+func (a *authFlags) removeToken() error {
+	path, err := expandHome(a.tokenPath())
+	if err != nil {
+		return fmt.Errorf("unable to resolve token path: %v", err)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Removes the cached token after the API rejected it, even after authorizing
+// again, so that the next run starts afresh, and returns the authError to
+// report.
+func (a *authFlags) rejected(err error) error {
+	if a.tokenFromEnv {
+		return authError{fmt.Errorf("the API rejected the token in %s, replace it to authorize again: %v", tokenJSONEnv, err)}
+	}
+	msg := "removed the cached token, run again to authorize"
+	if rmErr := a.removeToken(); rmErr != nil {
+		msg = fmt.Sprintf("remove %s to authorize again", a.tokenPath())
+	}
+	return authError{fmt.Errorf("the API rejected the token, %s: %v", msg, err)}
+}
+
+// Requests a token with the code the flow supplies, or else using the local
+// callback server when possible, falling back to pasting the authorization
+// code by hand.
+func getToken(ctx context.Context, config *oauth2.Config, flow tokenFlow, w io.Writer) (*oauth2.Token, error) {
+	if flow.code != nil {
+		return getTokenFromWeb(ctx, config, flow.code, nil)
+	}
+	if !flow.noBrowser {
+		ln, err := net.Listen("tcp", "localhost:0")
+		if err == nil {
+			tok, err := getTokenFromBrowser(ctx, config, ln, w)
+			if err != nil {
+				return nil, fmt.Errorf("unable to retrieve token from browser: %v", err)
+			}
+			return tok, nil
+		}
+		infof("Unable to start local callback server, falling back to manual code entry: %v", err)
+	}
+	return getTokenFromWeb(ctx, config, os.Stdin, w)
+}
+
+// Request a token from the web, reading the authorization code from codes,
+// then returns the retrieved token. The link to get the code from is written
+// to w, unless w is nil because the code was obtained beforehand.
+func getTokenFromWeb(ctx context.Context, config *oauth2.Config, codes io.Reader, w io.Writer) (*oauth2.Token, error) {
+	if w != nil {
+		authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
+		fmt.Fprintf(w, "Go to the following link in your browser then type the "+
+			"authorization code: \n%v\n", authURL)
+	}
+
+	var authCode string
+	if _, err := fmt.Fscan(codes, &authCode); err != nil {
+		return nil, fmt.Errorf("unable to read authorization code: %v", err)
+	}
+
+	tok, err := config.Exchange(ctx, authCode)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve token from web: %v", err)
+	}
+	return tok, nil
+}
+
+// Opens the auth URL in a browser and captures the authorization code from
+// the redirect to a temporary server listening on ln.
+func getTokenFromBrowser(ctx context.Context, config *oauth2.Config, ln net.Listener, w io.Writer) (*oauth2.Token, error) {
+	state, err := randomState()
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	cfg := *config
+	cfg.RedirectURL = "http://" + ln.Addr().String() + "/"
+
+	codes := make(chan string, 1)
+	errs := make(chan error, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		// Requests without our state did not come from the redirect we
+		// started, so reject them without ending the flow.
+		if q.Get("state") != state {
+			http.Error(w, "Invalid state parameter", http.StatusBadRequest)
+			return
+		}
+		if e := q.Get("error"); e != "" {
+			fmt.Fprintln(w, "Authorization failed, you may close this window.")
+			select {
+			case errs <- fmt.Errorf("authorization denied: %s", e):
+			default:
+			}
+			return
+		}
+		code := q.Get("code")
+		if code == "" {
+			http.Error(w, "Missing authorization code", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, "Authorization complete, you may close this window.")
+		select {
+		case codes <- code:
+		default:
+		}
+	})}
+	go srv.Serve(ln)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	authURL := cfg.AuthCodeURL(state, oauth2.AccessTypeOffline)
+	fmt.Fprintf(w, "Opening the following link in your browser to authorize access: \n%v\n", authURL)
+	if err := openBrowser(authURL); err != nil {
+		fmt.Fprintf(w, "Unable to open browser, visit the link manually: %v\n", err)
+	}
+
+	var code string
+	select {
+	case code = <-codes:
+	case err := <-errs:
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return cfg.Exchange(ctx, code)
+}
+
+// cachedToken is the contents of a token file: the token and the scope it
+// was granted. Files written before the scope was stored hold read-only
+// tokens.
+type cachedToken struct {
+	oauth2.Token
+	Scope string `json:"scope,omitempty"`
+}
+
+// Retrieves a token granting scope from a local file. Files that do not hold
+// a token, like ones truncated by an interrupted write, and tokens granting
+// too little access are reported as errors so that the caller authorizes
+// again. A token granting full access satisfies any scope.
+func tokenFromFile(file, scope string) (*cachedToken, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decodeToken(f, scope)
+}
+
+// Decodes a token granting scope in the form of a token file from r.
+func decodeToken(r io.Reader, scope string) (*cachedToken, error) {
+	cached := cachedToken{Scope: calendar.CalendarReadonlyScope}
+	if err := json.NewDecoder(r).Decode(&cached); err != nil {
+		return nil, fmt.Errorf("invalid token file: %v", err)
+	}
+	if cached.AccessToken == "" && cached.RefreshToken == "" {
+		return nil, errors.New("invalid token file: no access or refresh token")
+	}
+	if cached.Scope != scope && cached.Scope != calendar.CalendarScope {
+		return nil, fmt.Errorf("token grants %s, not %s", cached.Scope, scope)
+	}
+	return &cached, nil
+}
+
+// Saves a token granting scope to a file path. The token is written to a
+// temporary file that replaces path once complete, so an interrupted write
+// never leaves a partial token behind.
+func saveToken(path string, token *oauth2.Token, scope string) error {
+	infof("Saving credential file to: %s", path)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("unable to create token directory: %v", err)
+	}
+	// TempFile creates the file with 0600 permissions.
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("unable to cache oauth token: %v", err)
+	}
+	defer os.Remove(f.Name())
+	err = json.NewEncoder(f).Encode(cachedToken{*token, scope})
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to cache oauth token: %v", err)
+	}
+	return nil
+}
+
+// Returns the credentials file to use, preferring an explicit flag over the
+// environment and the environment over the default.
+func credentialsPath(flagValue string, explicit bool) string {
+	if !explicit {
+		if env := os.Getenv(credentialsEnv); env != "" {
+			return env
+		}
+	}
+	return flagValue
+}
+
+// Expands a leading "~/" in path to the current user's home directory.
+func expandHome(path string) (string, error) {
+	if !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[2:]), nil
+}
+
+// Generates an unguessable OAuth state value.
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Opens url with the platform's default browser.
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("xdg-open", url)
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		return errors.New("unsupported platform " + runtime.GOOS)
+	}
+	return cmd.Start()
+}
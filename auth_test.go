@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Sets the environment variable key to value, or unsets it when value is
+// empty, until the returned function restores it.
+func setenv(key, value string) func() {
+	saved, ok := os.LookupEnv(key)
+	if value == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+	return func() {
+		if ok {
+			os.Setenv(key, saved)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestCredentialsPathFromEnv(t *testing.T) {
+	defer setenv(credentialsEnv, "/etc/calendar/credentials.json")()
+	if got := credentialsPath("credentials.json", false); got != "/etc/calendar/credentials.json" {
+		t.Errorf("without --credentials got %q, want the environment's path", got)
+	}
+	if got := credentialsPath("mine.json", true); got != "mine.json" {
+		t.Errorf("with --credentials got %q, want the flag's path", got)
+	}
+}
+
+func TestCredentialsPathDefault(t *testing.T) {
+	defer setenv(credentialsEnv, "")()
+	if got := credentialsPath("credentials.json", false); got != "credentials.json" {
+		t.Errorf("got %q, want the default", got)
+	}
+}
+
+// Starts a token endpoint answering every authorization code with the access
+// token issued, and returns a config using it, the codes it was given and a
+// function stopping it.
+func tokenServer(t *testing.T, issued string) (*oauth2.Config, *[]string, func()) {
+	t.Helper()
+	var codes []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		codes = append(codes, r.Form.Get("code"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":%q,"token_type":"Bearer","expires_in":3600,"refresh_token":"refresh"}`, issued)
+	}))
+	config := &oauth2.Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Endpoint:     oauth2.Endpoint{AuthURL: ts.URL + "/auth", TokenURL: ts.URL + "/token"},
+		Scopes:       []string{calendar.CalendarReadonlyScope},
+	}
+	return config, &codes, ts.Close
+}
+
+// Makes os.Stdin read input, until the returned function restores it.
+func useStdin(t *testing.T, input string) func() {
+	t.Helper()
+	f, err := ioutil.TempFile("", "calendar-stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(input); err == nil {
+		_, err = f.Seek(0, 0)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		t.Fatal(err)
+	}
+	saved := os.Stdin
+	os.Stdin = f
+	return func() {
+		os.Stdin = saved
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+// Reads the access token cached in the token file at path.
+func cachedAccessToken(t *testing.T, path string) string {
+	t.Helper()
+	tok, err := tokenFromFile(path, calendar.CalendarReadonlyScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok.AccessToken
+}
+
+func TestCorruptTokenAuthorizesAgain(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "token.json")
+	// A token file cut short by an interrupted write.
+	if err := ioutil.WriteFile(path, []byte(`{"access_token":"ol`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, codes, stop := tokenServer(t, "fresh")
+	defer stop()
+	defer useStdin(t, "code-1\n")()
+	if _, err := getClient(context.Background(), config, path, "", tokenFlow{noBrowser: true}, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if len(*codes) != 1 {
+		t.Errorf("exchanged %d codes, want 1", len(*codes))
+	}
+	if got := cachedAccessToken(t, path); got != "fresh" {
+		t.Errorf("cached token %q, want fresh", got)
+	}
+	// The token is written to a temporary file renamed over the old one.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("left %d files in the token directory, want 1", len(files))
+	}
+}
+
+func TestTokenFromFileRejectsEmpty(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "token.json")
+	for _, s := range []string{``, `{}`, `{"access_token":`, `[]`} {
+		if err := ioutil.WriteFile(path, []byte(s), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tokenFromFile(path, calendar.CalendarReadonlyScope); err == nil {
+			t.Errorf("token %q was accepted", s)
+		}
+	}
+}
+
+func TestExpiredTokenWithoutRefreshAuthorizesAgain(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "token.json")
+	expired := &oauth2.Token{AccessToken: "old", Expiry: time.Now().Add(-time.Hour)}
+	if err := saveToken(path, expired, calendar.CalendarReadonlyScope); err != nil {
+		t.Fatal(err)
+	}
+	config, codes, stop := tokenServer(t, "fresh")
+	defer stop()
+	defer useStdin(t, "code-1\n")()
+	if _, err := getClient(context.Background(), config, path, "", tokenFlow{noBrowser: true}, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if len(*codes) != 1 {
+		t.Errorf("exchanged %d codes, want a new authorization", len(*codes))
+	}
+	if got := cachedAccessToken(t, path); got != "fresh" {
+		t.Errorf("cached token %q, want fresh", got)
+	}
+}
+
+func TestRefreshedTokenSaved(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "token.json")
+	expired := &oauth2.Token{AccessToken: "old", RefreshToken: "old-refresh", Expiry: time.Now().Add(-time.Hour)}
+	if err := saveToken(path, expired, calendar.CalendarScope); err != nil {
+		t.Fatal(err)
+	}
+	config, _, stop := tokenServer(t, "fresh")
+	defer stop()
+	if _, err := getClient(context.Background(), config, path, "", tokenFlow{noBrowser: true}, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	// The token server rotates the refresh token, and the token keeps the
+	// read-write scope it was granted.
+	tok, err := tokenFromFile(path, calendar.CalendarScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "fresh" || tok.RefreshToken != "refresh" {
+		t.Errorf("cached token %+v, want the refreshed one", tok.Token)
+	}
+
+	// A token from the environment is refreshed but never saved.
+	envPath := filepath.Join(dir, "env-token.json")
+	env := fmt.Sprintf(`{"access_token":"old","refresh_token":"old-refresh","expiry":%q}`, time.Now().Add(-time.Hour).Format(time.RFC3339))
+	if _, err := getClient(context.Background(), config, envPath, env, tokenFlow{noBrowser: true}, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(envPath); !os.IsNotExist(err) {
+		t.Errorf("saved the token from the environment to %s", envPath)
+	}
+}
+
+func TestCheckToken(t *testing.T) {
+	valid := &oauth2.Token{AccessToken: "a", Expiry: time.Now().Add(time.Hour)}
+	if got, err := checkToken(context.Background(), &oauth2.Config{}, valid); err != nil || got != valid {
+		t.Errorf("valid token: got %v, %v", got, err)
+	}
+	expired := &oauth2.Token{AccessToken: "a", Expiry: time.Now().Add(-time.Hour)}
+	_, err := checkToken(context.Background(), &oauth2.Config{}, expired)
+	if err != errTokenExpired || !isStale(err) {
+		t.Errorf("expired token without refresh token: got %v, want errTokenExpired", err)
+	}
+}
+
+func TestRejectedRemovesToken(t *testing.T) {
+	token, cleanup := tempToken(t)
+	defer cleanup()
+	a := authFlags{token: token}
+	err := a.rejected(errors.New("401"))
+	if _, ok := err.(authError); !ok {
+		t.Errorf("got %T, want authError", err)
+	}
+	if _, statErr := os.Stat(token); !os.IsNotExist(statErr) {
+		t.Error("kept the rejected token")
+	}
+}
+
+// rejectingService fails listing events with a 401 the first rejections
+// times.
+type rejectingService struct {
+	*fakeService
+	rejections int
+}
+
+func (s *rejectingService) ListEvents(ctx context.Context, calendarID string, q eventQuery, fn func(*calendar.Events) error) error {
+	if s.rejections > 0 {
+		s.rejections--
+		return &googleapi.Error{Code: http.StatusUnauthorized}
+	}
+	return s.fakeService.ListEvents(ctx, calendarID, q, fn)
+}
+
+func TestRejectedTokenAuthorizesAgain(t *testing.T) {
+	for _, c := range []struct {
+		rejections int
+		want       int
+	}{{1, exitOK}, {2, exitAuth}} {
+		token, cleanup := tempToken(t)
+		srv := &rejectingService{&fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(2, 10)}}, c.rejections}
+		connects := 0
+		saved := newCalendarService
+		newCalendarService = func(ctx context.Context, auth *authFlags, fs *flag.FlagSet, stderr io.Writer, retry retryPolicy) (CalendarService, error) {
+			connects++
+			return srv, nil
+		}
+		out, err := runCommand("--token", token, "--start", "2024-01-01", "--end", "2024-01-31", "--fields", "summary", "--no-header")
+		newCalendarService = saved
+		if got := exitCode(err); got != c.want {
+			t.Errorf("%d rejections: exit code %d (%v), want %d", c.rejections, got, err, c.want)
+		}
+		if c.want == exitOK && len(lines(out)) != 2 {
+			t.Errorf("%d rejections: wrote %q, want both events", c.rejections, lines(out))
+		}
+		if connects != 2 {
+			t.Errorf("%d rejections: authorized %d times, want 2", c.rejections, connects)
+		}
+		if _, statErr := os.Stat(token); !os.IsNotExist(statErr) {
+			t.Errorf("%d rejections: kept the rejected token", c.rejections)
+		}
+		cleanup()
+	}
+}
+
+func TestServiceAccountPath(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		flag     string
+		explicit map[string]bool
+		saEnv    string
+		credsEnv string
+		want     string
+	}{
+		{"flag", "key.json", nil, "", "", "key.json"},
+		{"flag over OAuth", "key.json", map[string]bool{"credentials": true}, "", "", "key.json"},
+		{"environment", "", nil, "/env/key.json", "", "/env/key.json"},
+		{"explicit OAuth credentials", "", map[string]bool{"credentials": true}, "/env/key.json", "", ""},
+		{"OAuth credentials from the environment", "", nil, "/env/key.json", "/env/credentials.json", ""},
+		{"none", "", nil, "", "", ""},
+	} {
+		restoreSA := setenv(serviceAccountEnv, c.saEnv)
+		restoreCreds := setenv(credentialsEnv, c.credsEnv)
+		a := authFlags{serviceAccount: c.flag}
+		if got := a.serviceAccountPath(c.explicit); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+		restoreCreds()
+		restoreSA()
+	}
+}
+
+func TestServiceAccountKeyRead(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "key.json")
+	if err := ioutil.WriteFile(path, []byte(`{"type":"authorized_user"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := serviceAccountClient(context.Background(), path, "", calendar.CalendarReadonlyScope)
+	if err == nil || !strings.Contains(err.Error(), "unable to parse service account key file") {
+		t.Errorf("got error %v, want the key file rejected", err)
+	}
+}
+
+// OAuth client credentials as downloaded for a desktop app.
+const testCredentialsJSON = `{"installed":{"client_id":"client","client_secret":"secret",` +
+	`"auth_uri":"https://accounts.google.com/o/oauth2/auth","token_uri":"https://oauth2.googleapis.com/token",` +
+	`"redirect_uris":["urn:ietf:wg:oauth:2.0:oob","http://localhost"]}}`
+
+func TestCredentialsAndTokenFromEnv(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	defer setenv(credentialsJSONEnv, testCredentialsJSON)()
+	defer setenv(credentialsEnv, "")()
+	defer setenv(tokenJSONEnv, fmt.Sprintf(`{"access_token":"env","expiry":%q}`, time.Now().Add(time.Hour).Format(time.RFC3339)))()
+	a := authFlags{credentials: filepath.Join(dir, "credentials.json"), token: filepath.Join(dir, "token.json")}
+	if _, err := a.oauthClient(context.Background(), nil, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if !a.tokenFromEnv || a.renewable() {
+		t.Error("a token from the environment is treated as renewable")
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("wrote %d files, want none", len(files))
+	}
+	// Explicit flags still read the files.
+	_, err = a.oauthClient(context.Background(), map[string]bool{"credentials": true}, ioutil.Discard)
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("with --credentials got error %v, want the file not found", err)
+	}
+}
+
+func TestTokenFromEnvNotSaved(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "token.json")
+	config, codes, stop := tokenServer(t, "fresh")
+	defer stop()
+	defer useStdin(t, "code-1\n")()
+	expired := fmt.Sprintf(`{"access_token":"old","expiry":%q}`, time.Now().Add(-time.Hour).Format(time.RFC3339))
+	if _, err := getClient(context.Background(), config, path, expired, tokenFlow{noBrowser: true}, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if len(*codes) != 1 {
+		t.Errorf("exchanged %d codes, want a new authorization", len(*codes))
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("saved the token from the environment to %s", path)
+	}
+}
+
+func TestAuthCodeFlags(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	codeFile := filepath.Join(dir, "code.txt")
+	if err := ioutil.WriteFile(codeFile, []byte("code-from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		a    authFlags
+		want string
+	}{
+		{authFlags{authCode: "code-from-flag"}, "code-from-flag"},
+		{authFlags{authCodeFile: codeFile}, "code-from-file"},
+	} {
+		flow, err := c.a.tokenFlow()
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, codes, stop := tokenServer(t, "fresh")
+		path := filepath.Join(dir, "token.json")
+		os.Remove(path)
+		_, err = getClient(context.Background(), config, path, "", flow, ioutil.Discard)
+		stop()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*codes) != 1 || (*codes)[0] != c.want {
+			t.Errorf("exchanged codes %q, want %q", *codes, c.want)
+		}
+		if got := cachedAccessToken(t, path); got != "fresh" {
+			t.Errorf("cached token %q, want fresh", got)
+		}
+	}
+	a := authFlags{authCode: "x", authCodeFile: codeFile}
+	if _, err := a.tokenFlow(); err == nil {
+		t.Error("--auth-code was accepted with --auth-code-file")
+	}
+}
+
+func TestWritesRequireReadWriteToken(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	defer setenv(credentialsJSONEnv, testCredentialsJSON)()
+	defer setenv(credentialsEnv, "")()
+	defer setenv(tokenJSONEnv, "")()
+	path := filepath.Join(dir, "token.json")
+	valid := &oauth2.Token{AccessToken: "a", RefreshToken: "r", Expiry: time.Now().Add(time.Hour)}
+	if err := saveToken(path, valid, calendar.CalendarReadonlyScope); err != nil {
+		t.Fatal(err)
+	}
+	a := authFlags{token: path, writes: true}
+	_, err := a.oauthClient(context.Background(), nil, ioutil.Discard)
+	if _, ok := err.(authError); !ok || !strings.Contains(err.Error(), "only grants read-only access, run again with --read-write") {
+		t.Errorf("with a read-only token got error %v, want an authError asking for --read-write", err)
+	}
+	if got := cachedAccessToken(t, path); got != "a" {
+		t.Errorf("cached token %q, want the read-only token kept", got)
+	}
+
+	if err := saveToken(path, valid, calendar.CalendarScope); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.oauthClient(context.Background(), nil, ioutil.Discard); err != nil {
+		t.Errorf("with a read-write token got error %v", err)
+	}
+
+	defer setenv(tokenJSONEnv, fmt.Sprintf(`{"access_token":"env","expiry":%q}`, time.Now().Add(time.Hour).Format(time.RFC3339)))()
+	_, err = a.oauthClient(context.Background(), nil, ioutil.Discard)
+	if err == nil || !strings.Contains(err.Error(), "the token in "+tokenJSONEnv+" only grants read-only access") {
+		t.Errorf("with a read-only token from the environment got error %v", err)
+	}
+}
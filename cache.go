@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// cachedService serves event listings from pages saved in dir by earlier
+// identical queries, for up to ttl after they were fetched.
+type cachedService struct {
+	CalendarService
+	dir string
+	ttl time.Duration
+	// now returns the current time; tests replace it.
+	now func() time.Time
+}
+
+// cacheEntry is the contents of a cache file.
+type cacheEntry struct {
+	Fetched time.Time          `json:"fetched"`
+	Pages   []*calendar.Events `json:"pages"`
+}
+
+// Returns the cache file for listing calendarID with q.
+func (c cachedService) path(calendarID string, q eventQuery) string {
+	key := strings.Join([]string{
+		calendarID,
+		q.timeMin.UTC().Format(time.RFC3339Nano),
+		q.timeMax.UTC().Format(time.RFC3339Nano),
+		q.text,
+		q.updatedMin.UTC().Format(time.RFC3339Nano),
+		strings.Join(q.properties, "\x01"),
+		strings.Join(q.sharedProperties, "\x01"),
+		q.orderBy,
+		fmt.Sprint(q.maxResults, q.showDeleted, q.recurring),
+	}, "\x00")
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Replays the cached pages for the query when they are fresh, and fetches
+// from the API otherwise, saving the pages that were fetched. When the cache
+// holds only the first pages of a listing and more are needed, the listing is
+// fetched again and the pages already replayed are skipped. Syncs are never
+// cached.
+func (c cachedService) ListEvents(ctx context.Context, calendarID string, q eventQuery, fn func(*calendar.Events) error) error {
+	if q.sync {
+		return c.CalendarService.ListEvents(ctx, calendarID, q, fn)
+	}
+	path := c.path(calendarID, q)
+	skip := 0
+	if entry, ok := c.load(path); ok {
+		debugf("serving %s from cache %s", calendarID, path)
+		for _, page := range entry.Pages {
+			if err := fn(page); err != nil {
+				return err
+			}
+		}
+		if n := len(entry.Pages); n > 0 && entry.Pages[n-1].NextPageToken == "" {
+			return nil
+		}
+		skip = len(entry.Pages)
+	}
+
+	entry := cacheEntry{Fetched: c.now()}
+	err := c.CalendarService.ListEvents(ctx, calendarID, q, func(page *calendar.Events) error {
+		entry.Pages = append(entry.Pages, page)
+		if len(entry.Pages) <= skip {
+			return nil
+		}
+		return fn(page)
+	})
+	if err == nil || err == errLimitReached {
+		if saveErr := c.save(path, entry); saveErr != nil {
+			infof("Unable to save cache: %v", saveErr)
+		}
+	}
+	return err
+}
+
+// Loads the cache file at path when it exists, is readable and is younger
+// than the TTL.
+func (c cachedService) load(path string) (cacheEntry, bool) {
+	var entry cacheEntry
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(b, &entry); err != nil {
+		debugf("ignoring unreadable cache %s: %v", path, err)
+		return entry, false
+	}
+	if c.now().Sub(entry.Fetched) > c.ttl {
+		return entry, false
+	}
+	return entry, true
+}
+
+func (c cachedService) save(path string, entry cacheEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+// Removes every cache file from dir.
+func clearCache(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// Returns a cache in a new directory over srv with a clock tests move by
+// setting *now, and a function removing the directory.
+func testCache(t *testing.T, srv CalendarService) (cachedService, *time.Time, func()) {
+	dir, cleanup := tempDir(t)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := cachedService{CalendarService: srv, dir: dir, ttl: time.Hour, now: func() time.Time { return now }}
+	return c, &now, cleanup
+}
+
+// Lists the events of primary in January through c and returns their IDs.
+func cachedIDs(t *testing.T, c cachedService) []string {
+	t.Helper()
+	var ids []string
+	err := c.ListEvents(context.Background(), "primary", januaryQuery(), func(page *calendar.Events) error {
+		for _, item := range page.Items {
+			ids = append(ids, item.Id)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestCacheHitAndMiss(t *testing.T) {
+	srv := &fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(5, 2)}}
+	c, _, cleanup := testCache(t, srv)
+	defer cleanup()
+	if got := cachedIDs(t, c); len(got) != 5 || srv.fetched != 3 {
+		t.Fatalf("miss listed %v after fetching %d pages, want 5 events from 3", got, srv.fetched)
+	}
+	srv.pages["primary"] = eventPages(1, 2)
+	if got := cachedIDs(t, c); len(got) != 5 || srv.fetched != 3 {
+		t.Errorf("hit listed %v after fetching %d pages, want the 5 cached events", got, srv.fetched)
+	}
+	// Another query is a miss.
+	q := januaryQuery()
+	q.text = "standup"
+	if err := c.ListEvents(context.Background(), "primary", q, func(*calendar.Events) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if srv.fetched != 4 {
+		t.Errorf("fetched %d pages, want another query fetched", srv.fetched)
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	srv := &fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(5, 2)}}
+	c, now, cleanup := testCache(t, srv)
+	defer cleanup()
+	cachedIDs(t, c)
+	srv.pages["primary"] = eventPages(1, 2)
+	*now = now.Add(59 * time.Minute)
+	if got := cachedIDs(t, c); len(got) != 5 {
+		t.Errorf("listed %v within the TTL, want the cached events", got)
+	}
+	*now = now.Add(2 * time.Minute)
+	if got := cachedIDs(t, c); len(got) != 1 {
+		t.Errorf("listed %v after the TTL, want the events fetched again", got)
+	}
+}
+
+func TestCacheKeepsTimesUnconverted(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	defer useService(&fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(2, 2)}})()
+	args := []string{"--cache-dir", dir, "--fields", "start", "--no-header", "--start", "2024-01-01", "--end", "2024-01-31"}
+	if _, err := runCommand(append(args, "--timezone", "Asia/Tokyo")...); err != nil {
+		t.Fatal(err)
+	}
+	out, err := runCommand(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lines(out); len(got) != 2 || got[0] != "2024-01-01T00:00:00Z" {
+		t.Errorf("wrote %q from the cache, want the times as fetched", got)
+	}
+}
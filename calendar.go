@@ -1,184 +1,882 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/csv"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
 )
 
-// Retrieve a token, saves the token, then returns the generated client.
-func getClient(config *oauth2.Config) *http.Client {
-	// The file token.json stores the user's access and refresh tokens, and is
-	// created automatically when the authorization flow completes for the first
-	// time.
-	tokFile := "token.json"
-	tok, err := tokenFromFile(tokFile)
-	if err != nil {
-		tok = getTokenFromWeb(config)
-		saveToken(tokFile, tok)
+func main() {
+	// Report writes to a closed pipe as errors rather than dying on SIGPIPE,
+	// so that piping into head exits cleanly.
+	signal.Ignore(syscall.SIGPIPE)
+	ctx, stop := interruptContext(context.Background())
+	err := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	if err != nil && err != flag.ErrHelp {
+		fmt.Fprintf(os.Stderr, "calendar: %v\n", err)
 	}
-	return config.Client(context.Background(), tok)
+	os.Exit(exitCode(err))
 }
 
-// Request a token from the web, then returns the retrieved token.
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the "+
-		"authorization code: \n%v\n", authURL)
-
-	var authCode string
-	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Fatalf("Unable to read authorization code: %v", err)
-	}
-
-	tok, err := config.Exchange(context.TODO(), authCode)
-	if err != nil {
-		log.Fatalf("Unable to retrieve token from web: %v", err)
+// Returns a context canceled by the first SIGINT or SIGTERM, so that commands
+// stop and close their output cleanly, and a func to stop listening. Further
+// signals are handled as usual and end the process at once.
+func interruptContext(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-c:
+			signal.Stop(c)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(c)
+		cancel()
 	}
-	return tok
 }
 
-// Retrieves a token from a local file.
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
+// Runs the command named by the first argument, or lists events when the
+// arguments start with a flag.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	command := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	var err error
+	switch command {
+	case "":
+		err = listEvents(ctx, args, stdout, stderr)
+	case "list-calendars":
+		err = listCalendars(ctx, args, stdout, stderr)
+	case "list-profiles":
+		err = listProfiles(ctx, args, stdout, stderr)
+	case "fields":
+		err = listFields(ctx, args, stdout, stderr)
+	case "instances":
+		err = listInstances(ctx, args, stdout, stderr)
+	case "get":
+		err = getEvent(ctx, args, stdout, stderr)
+	case "create":
+		err = createEvent(ctx, args, stdout, stderr)
+	case "delete":
+		err = deleteEvent(ctx, args, stdout, stderr)
+	case "watch":
+		err = watchEvents(ctx, args, stdout, stderr)
+	case "stop-watch":
+		err = stopWatch(ctx, args, stdout, stderr)
+	case "serve":
+		err = serveNotifications(ctx, args, stdout, stderr)
+	case "logout":
+		err = logout(ctx, args, stdout, stderr)
+	default:
+		return fmt.Errorf("unknown command %q, expected list-calendars, list-profiles, fields, instances, get, create, delete, watch, stop-watch, serve, logout or none to list events", command)
+	}
+	if err == errVersion {
+		fmt.Fprintln(stdout, versionString())
+		return nil
 	}
-	defer f.Close()
-	tok := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(tok)
-	return tok, err
+	return err
 }
 
-// Saves a token to a file path.
-func saveToken(path string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", path)
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+// Parses args with fs, reporting usage and parse errors to stderr, then fills
+// in the flags that were not given from the config file.
+func parseFlags(fs *flag.FlagSet, args []string, stderr io.Writer) error {
+	configPath := fs.String("config", defaultConfigPath, "YAML file of flag values to use when not given on the command line")
+	showVersion := fs.Bool("version", false, "Print the version and exit")
+	verbose := fs.Bool("verbose", false, "Log auth, paging and timing details to stderr")
+	fs.BoolVar(verbose, "v", false, "Shorthand for --verbose")
+	quiet := fs.Bool("quiet", false, "Only print errors and authorization prompts to stderr")
+	fs.BoolVar(quiet, "q", false, "Shorthand for --quiet")
+	fs.SetOutput(stderr)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *showVersion {
+		return errVersion
+	}
+	if err := applyConfig(fs, *configPath, visited(fs)["config"]); err != nil {
+		return err
 	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
+	setDebugOutput(nil)
+	if *verbose {
+		setDebugOutput(stderr)
+	}
+	infoOutput = stderr
+	if *quiet {
+		infoOutput = ioutil.Discard
+	}
+	return nil
 }
 
-/*
-	MaxResults sets the optional parameter "maxResults": Maximum number of events returned on one result page.
-	The number of events in the resulting page may be less than this value, or none at all, even if there are more events matching the query.
-	Incomplete pages can be detected by a non-empty nextPageToken field in the response. By default the value is 250 events.
-	The page size can never be larger than 2500 events.
+// Returns the names of the flags that were set on the command line or in the
+// config file.
+func visited(fs *flag.FlagSet) map[string]bool {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
 
-	Valid time units are "ns", "us" (or "µs"), "ms", "s", "m", "h".
-*/
-func main() {
+// Lists the events of the calendars in the time window chosen by args, and
+// writes them to stdout or the --output file in the chosen format.
+func listEvents(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("calendar", flag.ContinueOnError)
+	var auth authFlags
 	var limit int
-	var dateStartString string
-	var dateEndString string
-	var dateFromSpan time.Duration
-	var dateToSpan time.Duration
+	var format string
+	var calendarIDs stringList
+	var calendarName string
+	var fmtOpts formatOptions
+	var fieldsString string
+	var templateText string
+	var delimiter string
+	var templatePath string
+	var queryText string
+	var updatedAfter string
+	var sinceLastRun bool
+	var stateFile string
+	var properties propertyList
+	var sharedProperties propertyList
+	var window windowFlags
+	var timeout time.Duration
+	var concurrency int
+	var maxPages int
+	var sample int
+	var summaryLength int
+	var cacheDir string
+	var cacheTTL time.Duration
+	var noCache bool
+	var clearCacheFirst bool
+	var retry retryPolicy
+	var syncStatePath string
+	var showDeleted bool
+	var statuses stringList
+	var visibilities stringList
+	var creators stringList
+	var organizers stringList
+	var busyOnly bool
+	var expandRecurring bool
+	var orderBy string
+	var outputPath string
+	var gzipOutput bool
+	var appendOutput bool
+	var timezone string
+	var useCalendarTimezone bool
+	var summary bool
+	var mergeAdjacent bool
+	var mergeGap time.Duration
+	var groupBy string
+	var freeBusy bool
+	var failOnEmpty bool
+	var continueOnError bool
+	var dedup bool
+	var progress bool
+	var excludeDeclinedEvents bool
+	var minDuration time.Duration
+	var maxDuration time.Duration
+	var keepNoEnd bool
+	var skipNoStartEvents bool
+	var dateFormat string
+	var allDay bool
+	var timed bool
+	var dryRun bool
+	var reverse bool
 	var dateStart time.Time
 	var dateEnd time.Time
 	var err error
-	flag.IntVar(&limit, "limit", 250, "Limit number of entries")
-	flag.StringVar(&dateStartString, "start", "", "Start date RFC3339 format [2006-01-02T15:04:05Z] (default to now)")
-	flag.StringVar(&dateEndString, "end", "", "Start date RFC3339 format [2006-01-02T15:04:05Z] (default to now)")
-	flag.DurationVar(&dateFromSpan, "from", dateFromSpan, "Duration to subtract from start date: ")
-	flag.DurationVar(&dateToSpan, "to", dateToSpan, "Duration to add to end date")
-	flag.Parse()
-	ctx := context.Background()
-
-	if dateStartString == "" {
-		dateStart = time.Now()
-	} else {
-		dateStart, err = time.Parse(time.RFC3339, dateStartString)
-		if err != nil {
-			log.Fatalf("Unable to parse start date: %v", err)
+	auth.register(fs)
+	fs.IntVar(&limit, "limit", 250, "Limit number of entries")
+	fs.Var(&calendarIDs, "calendar", "Calendar ID to list events from, repeatable or comma-separated (default primary)")
+	fs.StringVar(&calendarName, "calendar-name", "", "Name of the calendar to list events from, as shown by list-calendars, instead of --calendar")
+	fs.StringVar(&queryText, "query", "", "Only list events matching this text in their summary, description, location or attendees")
+	fs.StringVar(&updatedAfter, "updated-after", "", "Only list events last modified after this time, in the same forms as --start")
+	fs.BoolVar(&sinceLastRun, "since-last-run", false, "Only list events modified since the last run with this flag, saving the time of this run in --state-file")
+	fs.StringVar(&stateFile, "state-file", "~/.config/calendar/last-run", "File keeping the time of the last run for --since-last-run")
+	fs.Var(&properties, "property", "Only list events with this key=value private extended property, repeatable")
+	fs.Var(&sharedProperties, "shared-property", "Only list events with this key=value shared extended property, repeatable")
+	fs.BoolVar(&showDeleted, "show-deleted", false, "Include cancelled events, see the status field")
+	fs.Var(&statuses, "status", "Only list events with this status: confirmed, tentative or cancelled, repeatable or comma-separated")
+	fs.BoolVar(&busyOnly, "busy-only", false, "Leave out events shown as free, whose transparency is transparent")
+	fs.Var(&visibilities, "visibility", "Only list events with this visibility: default, public, private or confidential, repeatable or comma-separated")
+	fs.Var(&creators, "creator", "Only list events created by this email address, repeatable or comma-separated; filtered after fetching")
+	fs.Var(&organizers, "organizer", "Only list events organized by this email address, repeatable or comma-separated; filtered after fetching")
+	fs.BoolVar(&expandRecurring, "expand-recurring", true, "List each instance of recurring events; when false list the recurring events with their recurrence rules")
+	fs.StringVar(&orderBy, "order-by", orderStartTime, "Order events by startTime, updated or none")
+	fs.BoolVar(&reverse, "reverse", false, "Write events in reverse order, newest first, holding them all in memory until the last arrives")
+	fs.StringVar(&outputPath, "output", "", "Write events to this file instead of stdout")
+	fs.StringVar(&outputPath, "o", "", "Shorthand for --output")
+	fs.BoolVar(&appendOutput, "append", false, "Add events to the end of the --output file, writing the header row only when it is empty")
+	fs.BoolVar(&gzipOutput, "gzip", false, "Compress the --output file with gzip, adding .gz to its name")
+	fs.StringVar(&timezone, "timezone", "", "Convert event times to this timezone, like America/New_York or UTC")
+	fs.BoolVar(&useCalendarTimezone, "use-calendar-timezone", false, "Convert event times to the time zone of the first calendar, unless --timezone is given")
+	fs.BoolVar(&summary, "summary", false, "Print the number of events, total scheduled time and busiest day instead of the events")
+	fs.BoolVar(&mergeAdjacent, "merge-adjacent", false, "With --summary, count overlapping and back-to-back events as one busy block")
+	fs.DurationVar(&mergeGap, "merge-gap", 0, "With --merge-adjacent, also join events at most this far apart, like 5m")
+	fs.StringVar(&groupBy, "group-by", "", "Print the number of events and busy hours per day, week or calendar instead of the events")
+	fs.BoolVar(&freeBusy, "freebusy", false, "Only list the merged periods when the calendars are busy, without event details")
+	fs.BoolVar(&excludeDeclinedEvents, "exclude-declined", false, "Leave out events you have declined")
+	fs.DurationVar(&minDuration, "min-duration", 0, "Leave out events shorter than this, all-day events count as 24h per day")
+	fs.DurationVar(&maxDuration, "max-duration", 0, "Leave out events longer than this, all-day events count as 24h per day")
+	fs.BoolVar(&keepNoEnd, "keep-no-end", false, "Keep events without an end time when filtering by duration")
+	fs.BoolVar(&skipNoStartEvents, "skip-no-start", false, "Leave out events without a start time, with a warning, instead of writing a blank start")
+	fs.BoolVar(&allDay, "all-day-only", false, "Only list all-day events")
+	fs.BoolVar(&timed, "timed-only", false, "Only list events with a start time, leaving out all-day events")
+	fs.StringVar(&format, "format", "csv", "Output format: csv, tsv, json, ndjson, ics, markdown, html, pretty, template or xlsx")
+	fs.StringVar(&fieldsString, "fields", defaultFields, "Comma-separated fields to write, or all; see the fields command")
+	fs.StringVar(&templateText, "template", "", `Go text/template rendering each event for --format template, like '{{.Start}}\t{{.Summary}}'`)
+	fs.StringVar(&templatePath, "template-file", "", "File holding the template for --format template")
+	fs.BoolVar(&fmtOpts.noHeader, "no-header", false, "Omit the CSV header row")
+	fs.BoolVar(&fmtOpts.htmlFull, "html-full", false, "Write a complete HTML document instead of only the table")
+	fs.StringVar(&delimiter, "delimiter", ",", `Character separating CSV columns, \t for a tab`)
+	fs.IntVar(&summaryLength, "truncate-summary", 0, "Cut summaries longer than this many characters, ending them with an ellipsis, 0 for no limit")
+	fs.StringVar(&dateFormat, "date-format", "", "Go layout or preset (rfc3339, kitchen, date) for the start and end fields of csv, tsv, markdown and html, like 'Mon Jan 2 3:04pm'")
+	fs.BoolVar(&fmtOpts.stripHTML, "strip-html", false, "Convert the HTML of the description field to plain text")
+	fs.BoolVar(&fmtOpts.jsonPretty, "json-pretty", false, "Indent the objects of --format json for reading")
+	fs.BoolVar(&fmtOpts.keepNewlines, "keep-newlines", false, "Keep line breaks within csv and tsv values instead of writing them as spaces")
+	fs.BoolVar(&fmtOpts.onlyEmail, "only-email", false, "List attendees by email address only, without display names")
+	window.register(fs)
+	fs.IntVar(&retry.maxRetries, "max-retries", 3, "Number of times to retry rate limited or failed API requests")
+	fs.StringVar(&syncStatePath, "sync-state", "", "File storing a sync token so repeated runs only list changed events")
+	fs.BoolVar(&failOnEmpty, "fail-on-empty", false, "Exit with status 3 when no events are found")
+	fs.StringVar(&cacheDir, "cache-dir", "", "Directory caching fetched events, so repeated identical queries skip the API")
+	fs.DurationVar(&cacheTTL, "cache-ttl", 10*time.Minute, "How long cached events are served before fetching again")
+	fs.BoolVar(&noCache, "no-cache", false, "Fetch from the API even when --cache-dir is set, without reading or writing the cache")
+	fs.BoolVar(&clearCacheFirst, "clear-cache", false, "Remove the cached events in --cache-dir before fetching")
+	fs.IntVar(&maxPages, "max-pages", 0, "Stop after fetching this many pages from each calendar, 0 for no limit")
+	fs.IntVar(&sample, "sample", 0, "List only the first few events of a single small page, to try out output settings cheaply")
+	fs.IntVar(&concurrency, "concurrency", fetchWorkers, "Maximum number of calendars to fetch at the same time")
+	fs.BoolVar(&progress, "progress", false, "Report the events fetched so far on stderr as pages arrive, which is done anyway when stderr is a terminal")
+	fs.BoolVar(&dedup, "dedup", false, "List events found in several calendars once, by iCalUID and start, from the first calendar given")
+	fs.BoolVar(&continueOnError, "continue-on-error", false, "List the events of the other calendars when one fails, exiting with status 4, instead of stopping")
+	fs.DurationVar(&timeout, "timeout", 30*time.Second, "Maximum time to spend fetching events, 0 for no limit")
+	fs.BoolVar(&dryRun, "dry-run", false, "Print the resolved requests to stderr and exit without authorizing or calling the API")
+	if err := parseFlags(fs, args, stderr); err != nil {
+		return err
+	}
+	now := timeNow()
+
+	dateStart, dateEnd, err = window.resolve(now)
+	if err != nil {
+		return fmt.Errorf("invalid time window: %v", err)
+	}
+
+	debugf("time window %s to %s", dateStart.Format(time.RFC3339), dateEnd.Format(time.RFC3339))
+
+	var updatedMin time.Time
+	if updatedAfter != "" {
+		if updatedMin, err = parseWhen(updatedAfter, now, false); err != nil {
+			return fmt.Errorf("invalid --updated-after: %v", err)
+		}
+	}
+	if sinceLastRun {
+		if updatedAfter != "" {
+			return errors.New("--since-last-run cannot be combined with --updated-after")
+		}
+		if syncStatePath != "" {
+			return errors.New("--since-last-run cannot be combined with --sync-state")
+		}
+		if stateFile, err = expandHome(stateFile); err != nil {
+			return fmt.Errorf("unable to resolve state file path: %v", err)
+		}
+		// On the first run, every event in the window is listed.
+		if updatedMin, err = loadLastRun(stateFile); err != nil {
+			return err
 		}
 	}
-	dateEnd = dateEnd.Add(dateFromSpan)
 
-	if dateEndString == "" {
-		dateEnd = time.Now()
-	} else {
-		dateEnd, err = time.Parse(time.RFC3339, dateEndString)
-		if err != nil {
-			log.Fatalf("Unable to parse start date: %v", err)
+	if !expandRecurring && orderBy == orderStartTime && !visited(fs)["order-by"] {
+		orderBy = orderNone
+	}
+	if err := checkOrderBy(orderBy, !expandRecurring); err != nil {
+		return err
+	}
+
+	if calendarName != "" {
+		if len(calendarIDs) > 0 {
+			return errors.New("--calendar-name cannot be combined with --calendar")
+		}
+		// Replaced by the ID of the calendar once connected.
+		calendarIDs = stringList{calendarName}
+	}
+	if len(calendarIDs) == 0 {
+		calendarIDs = stringList{"primary"}
+	}
+	for _, id := range calendarIDs {
+		if id == "" {
+			return errors.New("calendar ID must not be empty")
+		}
+	}
+	if freeBusy && fieldsString == defaultFields {
+		fieldsString = freeBusyFields
+	} else if fieldsString == defaultFields {
+		if len(calendarIDs) > 1 {
+			fieldsString += ",calendar"
+		}
+		if !expandRecurring {
+			fieldsString += ",recurrence"
 		}
 	}
-	dateEnd = dateEnd.Add(dateToSpan)
 
-	if !dateEnd.After(dateStart) {
-		log.Fatalf("End date must be after start date: %s -> %s", dateStart.Format(time.RFC3339), dateEnd.Format(time.RFC3339))
+	if maxPages < 0 {
+		return fmt.Errorf("--max-pages must not be negative, not %d", maxPages)
+	}
+	if concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1, not %d", concurrency)
+	}
+	if set := visited(fs); set["sample"] {
+		if sample < minResults || sample > maxResults {
+			return fmt.Errorf("--sample must be between %d and %d, not %d", minResults, maxResults, sample)
+		}
+		if set["limit"] || set["max-pages"] {
+			return errors.New("--sample cannot be combined with --limit or --max-pages")
+		}
+		// Requesting pages of sample events makes the first page all that
+		// is needed.
+		limit, maxPages = sample, 1
 	}
 
-	b, err := ioutil.ReadFile("credentials.json")
-	if err != nil {
-		log.Fatalf("Unable to read client secret file: %v", err)
+	if limit < minResults || limit > maxResults {
+		clamped := limit
+		if clamped < minResults {
+			clamped = minResults
+		} else {
+			clamped = maxResults
+		}
+		infof("Limit %d is outside the valid range [%d, %d], using %d", limit, minResults, maxResults, clamped)
+		limit = clamped
+	}
+
+	var state syncState
+	if freeBusy && syncStatePath != "" {
+		return errors.New("--freebusy cannot be combined with --sync-state")
+	}
+	if syncStatePath != "" {
+		set := visited(fs)
+		for _, name := range syncIncompatibleFlags {
+			if set[name] {
+				return fmt.Errorf("--%s cannot be combined with --sync-state", name)
+			}
+		}
+		if len(calendarIDs) > 1 {
+			return errors.New("--sync-state supports a single --calendar")
+		}
+		if sample > 0 {
+			return errors.New("--sample cannot be combined with --sync-state, which must fetch every page")
+		}
+		if maxPages > 0 {
+			return errors.New("--max-pages cannot be combined with --sync-state, which must fetch every page")
+		}
+		if syncStatePath, err = expandHome(syncStatePath); err != nil {
+			return fmt.Errorf("unable to resolve sync state path: %v", err)
+		}
+		if state, err = loadSyncState(syncStatePath); err != nil {
+			return err
+		}
+	} else if !dateEnd.After(dateStart) {
+		return fmt.Errorf("end date must be after start date: %s -> %s", dateStart.Format(time.RFC3339), dateEnd.Format(time.RFC3339))
 	}
 
-	// If modifying these scopes, delete your previously saved token.json.
-	config, err := google.ConfigFromJSON(b, calendar.CalendarReadonlyScope)
+	fmtOpts.fields, err = parseFields(fieldsString)
 	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
+		return fmt.Errorf("unable to parse fields: %v", err)
+	}
+	if err := checkFormat(format); err != nil {
+		return err
+	}
+	if dateFormat != "" {
+		if fmtOpts.dateLayout, err = parseDateLayout(dateFormat); err != nil {
+			return err
+		}
+	}
+	if fmtOpts.delimiter, err = parseDelimiter(delimiter); err != nil {
+		return err
+	}
+	if format == "tsv" && visited(fs)["delimiter"] {
+		return errors.New("--delimiter cannot be combined with --format tsv")
+	}
+	if gzipOutput {
+		if outputPath == "" {
+			return errors.New("--gzip requires --output")
+		}
+		if !strings.HasSuffix(outputPath, ".gz") {
+			outputPath += ".gz"
+		}
+	}
+	if appendOutput {
+		if outputPath == "" {
+			return errors.New("--append requires --output")
+		}
+		if format != "csv" && format != "tsv" && format != "ndjson" {
+			return fmt.Errorf("--append writes csv, tsv or ndjson, not %s", format)
+		}
+	}
+	if fmtOpts.jsonPretty && format != "json" {
+		return fmt.Errorf("--json-pretty requires --format json, not %s", format)
+	}
+	if format == "xlsx" && outputPath == "" {
+		return errors.New("--format xlsx writes a binary workbook and requires --output")
+	}
+	if format == "template" {
+		if fmtOpts.template, err = parseTemplate(templateText, templatePath); err != nil {
+			return err
+		}
+	}
+	if groupBy != "" {
+		if err := checkGrouping(groupBy, format); err != nil {
+			return err
+		}
+	}
+	if mergeAdjacent && !summary {
+		return errors.New("--merge-adjacent requires --summary")
+	}
+	if mergeGap < 0 {
+		return fmt.Errorf("--merge-gap must be zero or positive, not %v", mergeGap)
+	}
+	if mergeGap > 0 && !mergeAdjacent {
+		return errors.New("--merge-gap requires --merge-adjacent")
+	}
+	if reverse && (summary || groupBy != "") {
+		return errors.New("--reverse cannot be combined with --summary or --group-by")
+	}
+	for _, status := range statuses {
+		if !eventStatuses[status] {
+			return fmt.Errorf("unknown status %q, expected confirmed, tentative or cancelled", status)
+		}
+		if status == "cancelled" && !showDeleted {
+			return errors.New("--status cancelled requires --show-deleted, without which cancelled events are not listed")
+		}
+	}
+	for _, v := range visibilities {
+		if !eventVisibilities[v] {
+			return fmt.Errorf("unknown visibility %q, expected default, public, private or confidential", v)
+		}
+	}
+	if allDay && timed {
+		return errors.New("--all-day-only cannot be combined with --timed-only")
+	}
+	if minDuration < 0 || maxDuration < 0 {
+		return errors.New("--min-duration and --max-duration must not be negative")
+	}
+	if maxDuration > 0 && minDuration > maxDuration {
+		return fmt.Errorf("--min-duration %v is longer than --max-duration %v", minDuration, maxDuration)
+	}
+	collector := EventCollector{limit: limit, maxPages: maxPages}
+	if skipNoStartEvents {
+		collector.filters = append(collector.filters, skipNoStart())
+	}
+	if minDuration > 0 || maxDuration > 0 {
+		collector.filters = append(collector.filters, durationBetween(minDuration, maxDuration, keepNoEnd))
+	}
+	if allDay || timed {
+		collector.filters = append(collector.filters, allDayOnly(allDay))
+	}
+	if len(statuses) > 0 {
+		collector.filters = append(collector.filters, statusIn(statuses))
+	}
+	if busyOnly {
+		collector.filters = append(collector.filters, excludeFree)
+	}
+	if len(visibilities) > 0 {
+		collector.filters = append(collector.filters, visibilityIn(visibilities))
+	}
+	if len(creators) > 0 {
+		collector.filters = append(collector.filters, personIn(creatorEmail, creators))
+	}
+	if len(organizers) > 0 {
+		collector.filters = append(collector.filters, personIn(organizerEmail, organizers))
+	}
+	if summaryLength < 0 {
+		return fmt.Errorf("--truncate-summary must not be negative, not %d", summaryLength)
+	}
+	collector.summaryLength = summaryLength
+	collector.dedup = dedup
+	// Progress would be mixed up with the --verbose log, so it is only
+	// shown on a terminal without it.
+	if progress || isTerminal(infoOutput) && debugLog == nil {
+		collector.progress = newProgressLine(stderr)
+	}
+	if timezone != "" {
+		if collector.timezone, err = time.LoadLocation(timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q: %v", timezone, err)
+		}
+	}
+
+	query := eventQuery{timeMin: dateStart, timeMax: dateEnd, maxResults: int64(limit), text: queryText, updatedMin: updatedMin, properties: properties, sharedProperties: sharedProperties, showDeleted: showDeleted, recurring: !expandRecurring, orderBy: orderBy, sync: state != nil}
+	if dryRun {
+		if calendarName != "" {
+			fmt.Fprintf(stderr, "calendarList: summary=%q\n", calendarName)
+		}
+		printDryRun(stderr, query, calendarIDs, state, freeBusy, format, fieldsString)
+		return nil
 	}
-	client := getClient(config)
 
-	srv, err := calendar.New(client)
+	lister, err := connect(ctx, &auth, fs, stderr, retry)
 	if err != nil {
-		log.Fatalf("Unable to retrieve Calendar client: %v", err)
+		return err
+	}
+
+	if cacheDir != "" {
+		if cacheDir, err = expandHome(cacheDir); err != nil {
+			return fmt.Errorf("unable to resolve cache path: %v", err)
+		}
+		if clearCacheFirst {
+			if err := clearCache(cacheDir); err != nil {
+				return fmt.Errorf("unable to clear cache: %v", err)
+			}
+		}
+		if !noCache {
+			lister = cachedService{CalendarService: lister, dir: cacheDir, ttl: cacheTTL, now: time.Now}
+		}
+	}
+
+	if calendarName != "" {
+		if calendarIDs[0], err = calendarByName(ctx, lister, calendarName); err != nil {
+			return fmt.Errorf("unable to find calendar: %v", err)
+		}
+	}
+
+	if useCalendarTimezone && timezone == "" {
+		if collector.timezone, err = calendarTimezone(ctx, lister, calendarIDs[0]); err != nil {
+			return fmt.Errorf("unable to look up calendar time zone: %v", err)
+		}
+		debugf("using time zone %s of calendar %s", collector.timezone, calendarIDs[0])
+	}
+
+	if excludeDeclinedEvents {
+		email, err := primaryEmail(ctx, lister)
+		if err != nil {
+			return fmt.Errorf("unable to look up your email address: %v", err)
+		}
+		collector.filters = append(collector.filters, excludeDeclined(email))
 	}
 
-	var collector EventCollector
-	fetchEventCtx, fetchEventCancel := context.WithTimeout(ctx, 10*time.Second)
+	if err := loadColors(ctx, lister, &fmtOpts); err != nil {
+		return fmt.Errorf("unable to look up event colors: %v", err)
+	}
+
+	out := stdout
+	var outFile *os.File
+	if outputPath != "" && appendOutput {
+		if outFile, err = os.OpenFile(outputPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666); err != nil {
+			return fmt.Errorf("unable to open output file: %v", err)
+		}
+		defer outFile.Close()
+		info, err := outFile.Stat()
+		if err != nil {
+			return fmt.Errorf("unable to open output file: %v", err)
+		}
+		// The events already in the file follow its header row.
+		if info.Size() > 0 {
+			fmtOpts.noHeader = true
+		}
+		out = outFile
+	} else if outputPath != "" {
+		if outFile, err = os.Create(outputPath); err != nil {
+			return fmt.Errorf("unable to create output file: %v", err)
+		}
+		defer outFile.Close()
+		out = outFile
+	}
+	var gz *gzip.Writer
+	if gzipOutput {
+		gz = gzip.NewWriter(out)
+		out = gz
+	}
+	var formatter Formatter
+	if groupBy != "" {
+		r := groupRange{start: dateStart, end: dateEnd, loc: collector.timezone, calendars: calendarIDs}
+		if state != nil {
+			r.start, r.end = time.Time{}, time.Time{}
+		}
+		if formatter, err = newGroupFormatter(out, groupBy, r, format, fmtOpts); err != nil {
+			return err
+		}
+	} else if summary {
+		sf := newSummaryFormatter(out)
+		sf.merge, sf.mergeGap = mergeAdjacent, mergeGap
+		formatter = sf
+	} else if formatter, err = newFormatter(format, out, fmtOpts); err != nil {
+		return err
+	}
+	if reverse {
+		formatter = &reversedFormatter{Formatter: formatter}
+	}
+
+	fetchEventCtx, fetchEventCancel := context.WithCancel(ctx)
+	if timeout > 0 {
+		fetchEventCtx, fetchEventCancel = context.WithTimeout(ctx, timeout)
+	}
 	defer fetchEventCancel()
-	err = srv.Events.List("primary").ShowDeleted(false).SingleEvents(true).
-		TimeMin(dateStart.Format(time.RFC3339)).TimeMax(dateEnd.Format(time.RFC3339)).
-		MaxResults(10).OrderBy("startTime").Pages(fetchEventCtx, collector.WriteCallback(fetchEventCtx, os.Stdout))
+	fetchStart := time.Now()
+	var collected int
+	var partial error
+	if freeBusy {
+		collected, err = writeFreeBusy(fetchEventCtx, lister, calendarIDs, dateStart, dateEnd, collector.timezone, formatter)
+	} else if len(calendarIDs) == 1 {
+		id := calendarIDs[0]
+		collector.calendar = id
+		if state != nil {
+			// The sync token only comes with the last page, so a sync
+			// must never stop early.
+			collector.limit = 0
+			query.syncToken = state[id]
+		}
+		err = fetchSync(fetchEventCtx, lister, id, query, collector.WriteCallback(fetchEventCtx, formatter))
+		collected = collector.itemCounter
+		if err == nil && state != nil {
+			state[id] = collector.nextSyncToken
+			if err := state.save(syncStatePath); err != nil {
+				return fmt.Errorf("unable to save sync state: %v", err)
+			}
+		}
+	} else {
+		var events []*Event
+		events, err = fetchMerged(fetchEventCtx, lister, calendarIDs, query, &collector, concurrency, continueOnError)
+		if failed, ok := err.(multiError); ok && continueOnError && !isUnauthorized(failed) {
+			for _, err := range failed {
+				fmt.Fprintf(stderr, "Unable to retrieve events of %v\n", err)
+			}
+			partial, err = partialError{failed, len(calendarIDs)}, nil
+		}
+		collected = len(events)
+		for _, item := range events {
+			if err != nil {
+				break
+			}
+			err = formatter.WriteEvent(item)
+		}
+	}
+	collector.progress.done()
+	debugf("collected %d events in %v", collected, time.Since(fetchStart).Round(time.Millisecond))
+	if !freeBusy && !isBrokenPipe(err) {
+		infof("fetched %d pages, %d events", collector.pageCounter, collected)
+		if collector.truncated && sample == 0 {
+			infof("Stopped after %d pages of a calendar, the events are truncated; raise --max-pages to fetch more", maxPages)
+		}
+	}
+	if isBrokenPipe(err) {
+		return nil
+	}
+	// Ends the output, so that the events written so far form a complete
+	// file even when the listing stopped early.
+	closeOutput := func() error {
+		if err := formatter.Close(); err != nil && !isBrokenPipe(err) {
+			return fmt.Errorf("unable to write events: %v", err)
+		}
+		// Closing writes the end of the gzip stream, without which the
+		// file is truncated.
+		if gz != nil {
+			if err := gz.Close(); err != nil {
+				return fmt.Errorf("unable to write events: %v", err)
+			}
+		}
+		if outFile != nil {
+			if err := outFile.Close(); err != nil {
+				return fmt.Errorf("unable to write events: %v", err)
+			}
+		}
+		return nil
+	}
+	if ctx.Err() == context.Canceled {
+		if err := closeOutput(); err != nil {
+			return err
+		}
+		infof("Interrupted after writing %d events", collector.itemCounter)
+		return errInterrupted
+	}
+	if fetchEventCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v with %d events collected, use --timeout to allow longer", timeout, collected)
+	}
+	if isUnauthorized(err) {
+		return auth.rejected(err)
+	}
 	if err != nil {
-		log.Fatalf("Unable to retrieve events: %v", err)
+		return fmt.Errorf("unable to retrieve events: %v", err)
+	}
+	if err := closeOutput(); err != nil {
+		return err
+	}
+	// The changes to the calendars that failed are listed by the next run.
+	if partial != nil {
+		return partial
+	}
+	if sinceLastRun {
+		if err := saveLastRun(stateFile, now); err != nil {
+			return fmt.Errorf("unable to save state file: %v", err)
+		}
+	}
+	if failOnEmpty && collected == 0 {
+		return errNoEvents
+	}
+	return nil
+}
+
+// Writes the requests listEvents would make for --dry-run, followed by the
+// output settings.
+func printDryRun(w io.Writer, q eventQuery, calendarIDs []string, state syncState, freeBusy bool, format, fields string) {
+	if freeBusy {
+		fmt.Fprintf(w, "freebusy %s: timeMin=%s timeMax=%s\n", strings.Join(calendarIDs, ","),
+			q.timeMin.Format(time.RFC3339), q.timeMax.Format(time.RFC3339))
+	} else {
+		for _, id := range calendarIDs {
+			if q.sync {
+				q.syncToken = state[id]
+			}
+			fmt.Fprintf(w, "events %s: %s\n", id, strings.Join(q.params(), " "))
+		}
 	}
+	fmt.Fprintf(w, "format: %s\nfields: %s\n", format, fields)
 }
 
-func WriteEvent(w *csv.Writer, item *calendar.Event) error {
-	date := item.Start.DateTime
-	if date == "" {
-		date = item.Start.Date
+func WriteEvent(w *csv.Writer, item *Event, fields []field, o *fieldOptions) error {
+	return w.Write(fieldValues(fields, item, o))
+}
+
+// Reports whether err comes from writing to a pipe whose reader has exited,
+// as when the output is piped into head.
+func isBrokenPipe(err error) bool {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
 	}
-	return w.Write([]string{date, item.Summary})
+	return err == syscall.EPIPE
+}
 
+// Reports whether err is an API error with a 404 status.
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == http.StatusNotFound
 }
 
+// Bounds on the number of events the API will return in a single page.
+const (
+	minResults = 1
+	maxResults = 2500
+)
+
+// errLimitReached is returned from the paging callback to stop fetching once
+// the collector has written as many events as it was asked for.
+var errLimitReached = errors.New("event limit reached")
+
+// errMaxPages is returned from the paging callback to stop fetching once the
+// collector has been passed as many pages as it may fetch.
+var errMaxPages = errors.New("page cap reached")
+
+// EventCollector writes pages of events to a Formatter as they arrive,
+// counting pages and events and stopping at the limit. It keeps no events;
+// write to an eventBuffer to hold them in memory.
 type EventCollector struct {
-	events      []*calendar.Events
 	pageCounter int
 	itemCounter int
+	// limit is the total number of events to write; zero means no limit.
+	limit int
+	// maxPages is the number of pages to fetch; zero means no limit.
+	maxPages int
+	// truncated is set when paging stopped at maxPages with pages left.
+	truncated bool
+	// calendar is the ID of the calendar being collected.
+	calendar string
+	// nextSyncToken is the sync token from the last page, if any.
+	nextSyncToken string
+	// timezone, when set, is the location event times are converted to.
+	timezone *time.Location
+	// summaryLength, when positive, is the number of characters summaries
+	// are cut to.
+	summaryLength int
+	// filters drop events before they are counted or written.
+	filters []eventFilter
+	// progress, when set, is told about each page fetched.
+	progress *progressLine
+	// dedup drops the copies of events listed from several calendars. As
+	// the calendars are fetched concurrently, their events are buffered and
+	// the copies dropped when they are merged, keeping the first calendar's.
+	dedup bool
+}
+
+// Reports whether every filter keeps item.
+func (c *EventCollector) keep(item *Event) bool {
+	for _, f := range c.filters {
+		if !f(item) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns s cut to at most n characters, ending with an ellipsis when cut, or
+// s itself when n is zero.
+func truncate(s string, n int) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
 }
 
-func (c *EventCollector) WriteCallback(ctx context.Context, w io.Writer) func(e *calendar.Events) error {
-	csvWriter := csv.NewWriter(w)
+func (c *EventCollector) WriteCallback(ctx context.Context, f Formatter) func(e *calendar.Events) error {
 	return func(e *calendar.Events) error {
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
 		c.pageCounter++
-		c.itemCounter += len(e.Items)
+		metrics.pageFetched()
+		written := c.itemCounter
+		if e.NextSyncToken != "" {
+			c.nextSyncToken = e.NextSyncToken
+		}
 		for _, item := range e.Items {
-			err := WriteEvent(csvWriter, item)
+			if c.limit > 0 && c.itemCounter >= c.limit {
+				break
+			}
+			event := &Event{Event: item, Calendar: c.calendar}
+			if !c.keep(event) {
+				continue
+			}
+			if c.timezone != nil || c.summaryLength > 0 {
+				// Change a copy, as the page may also be saved to the cache.
+				changed := *item
+				if c.timezone != nil {
+					changed.Start = convertedEventTime(item.Start, c.timezone)
+					changed.End = convertedEventTime(item.End, c.timezone)
+					changed.OriginalStartTime = convertedEventTime(item.OriginalStartTime, c.timezone)
+				}
+				changed.Summary = truncate(item.Summary, c.summaryLength)
+				event.Event = &changed
+			}
+			err := f.WriteEvent(event)
 			if err != nil {
 				return err
 			}
-			csvWriter.Flush()
+			c.itemCounter++
+			metrics.eventWritten()
+		}
+		c.progress.page(c.itemCounter - written)
+		if err := f.Flush(); err != nil {
+			return err
+		}
+		if c.limit > 0 && c.itemCounter >= c.limit {
+			return errLimitReached
+		}
+		if c.maxPages > 0 && c.pageCounter >= c.maxPages && e.NextPageToken != "" {
+			c.truncated = true
+			return errMaxPages
 		}
 		return nil
 	}
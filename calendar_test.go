@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+func TestLimitStopsPaging(t *testing.T) {
+	collector := EventCollector{limit: 5}
+	out, fetched, err := collectCSV(&collector, formatOptions{noHeader: true, fields: mustParseFields(t, "start,summary")}, eventPages(20, 10))
+	if err != errLimitReached {
+		t.Fatalf("got %v, want errLimitReached", err)
+	}
+	if got := lines(out); len(got) != 5 || !strings.HasSuffix(got[4], ",Event e5") {
+		t.Errorf("wrote %q, want events 1 to 5", got)
+	}
+	if fetched != 1 {
+		t.Errorf("fetched %d pages, want 1", fetched)
+	}
+}
+
+func TestNoLimitWritesAllPages(t *testing.T) {
+	collector := EventCollector{}
+	out, fetched, err := collectCSV(&collector, formatOptions{noHeader: true, fields: mustParseFields(t, "start,summary")}, eventPages(20, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lines(out); len(got) != 20 || fetched != 2 {
+		t.Errorf("wrote %d events from %d pages, want 20 from 2", len(got), fetched)
+	}
+}
+
+func TestLimitClamped(t *testing.T) {
+	for _, c := range []struct {
+		limit string
+		want  int64
+	}{{"0", minResults}, {"5000", maxResults}} {
+		srv := &fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(3, 10)}}
+		restore := useService(srv)
+		_, err := runCommand("--start", "2024-01-01", "--end", "2024-01-31", "--limit", c.limit)
+		restore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := srv.query("primary").maxResults; got != c.want {
+			t.Errorf("--limit %s: maxResults = %d, want %d", c.limit, got, c.want)
+		}
+	}
+}
+
+func TestMaxPagesStopsPaging(t *testing.T) {
+	for _, c := range []struct {
+		args   []string
+		events int
+		pages  int
+	}{
+		{[]string{"--max-pages", "3"}, 6, 3},
+		{[]string{"--max-pages", "3", "--limit", "3"}, 3, 2},
+		{[]string{"--max-pages", "3", "--limit", "100"}, 6, 3},
+	} {
+		srv := &fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(20, 2)}}
+		restore := useService(srv)
+		var stdout, stderr bytes.Buffer
+		err := run(context.Background(), append([]string{"--start", "2024-01-01", "--end", "2024-01-31", "--fields", "id", "--no-header"}, c.args...), &stdout, &stderr)
+		restore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := lines(stdout.String()); len(got) != c.events {
+			t.Errorf("%v: wrote %d events, want %d", c.args, len(got), c.events)
+		}
+		if srv.fetched != c.pages {
+			t.Errorf("%v: fetched %d pages, want %d", c.args, srv.fetched, c.pages)
+		}
+		if truncated := strings.Contains(stderr.String(), "events are truncated"); truncated != (c.pages == 3) {
+			t.Errorf("%v: reported %q", c.args, stderr.String())
+		}
+	}
+}
+
+func TestSample(t *testing.T) {
+	srv := &fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(20, 8)}}
+	defer useService(srv)()
+	var stdout, stderr bytes.Buffer
+	args := []string{"--start", "2024-01-01", "--end", "2024-01-31", "--fields", "id", "--no-header", "--sample", "3"}
+	if err := run(context.Background(), args, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if got := lines(stdout.String()); strings.Join(got, " ") != "e1 e2 e3" {
+		t.Errorf("wrote %q, want the first 3 events", got)
+	}
+	if got := srv.query("primary").maxResults; got != 3 {
+		t.Errorf("requested pages of %d events, want 3", got)
+	}
+	if got := stderr.String(); !strings.Contains(got, "fetched 1 pages, 3 events") || strings.Contains(got, "truncated") {
+		t.Errorf("reported %q, want a single page without a truncation note", got)
+	}
+	for _, extra := range [][]string{{"--sample", "0"}, {"--sample", "3", "--limit", "10"}, {"--sample", "3", "--max-pages", "2"}} {
+		if _, err := runCommand(append([]string{"--start", "2024-01-01", "--end", "2024-01-31"}, extra...)...); err == nil {
+			t.Errorf("%v succeeded", extra)
+		}
+	}
+}
+
+func TestTruncateSummary(t *testing.T) {
+	long := timedEvent("l1", "2024-01-15T10:00:00Z", time.Hour)
+	long.Summary = "Café planning über alles 🎉 with everyone"
+	short := timedEvent("s1", "2024-01-15T12:00:00Z", time.Hour)
+	short.Summary = "Café 🎉"
+	defer useService(serviceWith(long, short))()
+	for _, c := range []struct {
+		format string
+		want   []string
+	}{
+		{"csv", []string{"l1,Café plann…", "s1,Café 🎉"}},
+		{"template", []string{"Café plann…", "Café 🎉"}},
+	} {
+		out, err := runCommand("--start", "2024-01-01", "--end", "2024-01-31", "--fields", "id,summary", "--no-header",
+			"--format", c.format, "--template", "{{.Summary}}\n", "--truncate-summary", "11")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := lines(out); strings.Join(got, "|") != strings.Join(c.want, "|") {
+			t.Errorf("--format %s wrote %q, want %q", c.format, got, c.want)
+		}
+	}
+	if got := truncate("🎉🎉🎉", 2); got != "🎉…" {
+		t.Errorf("truncate cut to %q, want whole characters", got)
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want bool
+	}{
+		{&googleapi.Error{Code: 404}, true},
+		{&googleapi.Error{Code: 403}, false},
+		{errors.New("not found"), false},
+		{nil, false},
+	} {
+		if got := isNotFound(c.err); got != c.want {
+			t.Errorf("isNotFound(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestRunUnknownCommand(t *testing.T) {
+	_, err := runCommand("lsit-calendars")
+	if err == nil || !strings.Contains(err.Error(), `unknown command "lsit-calendars"`) {
+		t.Errorf("got error %v, want unknown command", err)
+	}
+	if got := exitCode(err); got != exitError {
+		t.Errorf("exit code %d, want %d", got, exitError)
+	}
+}
+
+func TestRunHelp(t *testing.T) {
+	_, err := runCommand("--help")
+	if err != flag.ErrHelp {
+		t.Errorf("got error %v, want flag.ErrHelp", err)
+	}
+	if got := exitCode(err); got != exitOK {
+		t.Errorf("exit code %d, want %d", got, exitOK)
+	}
+}
+
+func TestCalendarFlag(t *testing.T) {
+	srv := &fakeService{pages: map[string][]*calendar.Events{"team@group.calendar.google.com": eventPages(2, 10)}}
+	defer useService(srv)()
+	out, err := runCommand("--calendar", "team@group.calendar.google.com", "--start", "2024-01-01", "--end", "2024-01-31", "--fields", "summary", "--no-header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lines(out); len(got) != 2 {
+		t.Errorf("wrote %q, want the 2 events of the calendar", got)
+	}
+	if _, ok := srv.queries["primary"]; ok {
+		t.Error("listed the primary calendar too")
+	}
+}
+
+func TestCalendarNotFound(t *testing.T) {
+	defer useService(&fakeService{})()
+	_, err := runCommand("--calendar", "nobody", "--start", "2024-01-01", "--end", "2024-01-31")
+	if err == nil || !strings.Contains(err.Error(), `calendar "nobody" not found or not accessible`) {
+		t.Errorf("got error %v, want calendar not found", err)
+	}
+}
+
+func TestCalendarName(t *testing.T) {
+	srv := &fakeService{
+		pages: map[string][]*calendar.Events{"ops@group.calendar.google.com": eventPages(2, 10)},
+		calendars: []*calendar.CalendarListEntry{
+			{Id: "me@example.com", Summary: "me@example.com", Primary: true},
+			{Id: "ops@group.calendar.google.com", Summary: "Team Ops"},
+			{Id: "holidays@group.calendar.google.com", Summary: "Holidays"},
+			{Id: "holidays2@group.calendar.google.com", Summary: "holidays"},
+		},
+	}
+	defer useService(srv)()
+	args := []string{"--start", "2024-01-01", "--end", "2024-01-31", "--fields", "id,calendar", "--no-header", "--calendar-name"}
+	out, err := runCommand(append(args, "team ops")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lines(out); len(got) != 2 || got[0] != "e1,ops@group.calendar.google.com" {
+		t.Errorf("wrote %q, want the 2 events of Team Ops", got)
+	}
+	for _, c := range []struct {
+		name string
+		want string
+	}{
+		{"Holidays", `2 calendars are named "Holidays", pass one of their IDs with --calendar: holidays@group.calendar.google.com, holidays2@group.calendar.google.com`},
+		{"Team", `no calendar is named "Team", expected one of "me@example.com", "Team Ops", "Holidays", "holidays"`},
+	} {
+		_, err := runCommand(append(args, c.name)...)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("--calendar-name %q got error %v, want %s", c.name, err, c.want)
+		}
+	}
+	if _, err := runCommand(append(args, "Team Ops", "--calendar", "primary")...); err == nil {
+		t.Error("--calendar-name with --calendar succeeded")
+	}
+}
+
+func TestUseCalendarTimezone(t *testing.T) {
+	srv := &fakeService{
+		pages: map[string][]*calendar.Events{"primary": eventPages(1, 10), "team": eventPages(1, 10)},
+		calendars: []*calendar.CalendarListEntry{
+			{Id: "me@example.com", Primary: true, TimeZone: "Asia/Tokyo"},
+			{Id: "team", TimeZone: "America/New_York"},
+		},
+	}
+	defer useService(srv)()
+	args := []string{"--start", "2024-01-01", "--end", "2024-01-31", "--calendar", "primary", "--calendar", "team", "--fields", "start", "--no-header", "--use-calendar-timezone"}
+	out, err := runCommand(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// e1 starts at midnight UTC, converted to the primary calendar's zone
+	// for both calendars.
+	if got, want := strings.Join(lines(out), " "), "2024-01-01T09:00:00+09:00 2024-01-01T09:00:00+09:00"; got != want {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+	if srv.calendarGets != 1 {
+		t.Errorf("looked up %d calendars, want the time zone fetched once", srv.calendarGets)
+	}
+	out, err = runCommand(append(args, "--timezone", "UTC")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lines(out); len(got) != 2 || got[0] != "2024-01-01T00:00:00Z" {
+		t.Errorf("wrote %q, want --timezone to take precedence", got)
+	}
+	if srv.calendarGets != 1 {
+		t.Errorf("looked up the calendar time zone despite --timezone")
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	srv := &fakeService{
+		pages: map[string][]*calendar.Events{"mine": eventPages(2, 10)},
+		errs:  map[string]error{"shared": &googleapi.Error{Code: 403, Message: "Forbidden"}},
+	}
+	defer useService(srv)()
+	args := []string{"--calendar", "mine,shared", "--start", "2024-01-01", "--end", "2024-01-31", "--fields", "id", "--no-header"}
+
+	out, err := runCommand(args...)
+	if exitCode(err) != exitError || out != "" {
+		t.Errorf("without --continue-on-error wrote %q with error %v, want nothing and exit status %d", out, err, exitError)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = run(context.Background(), append(args, "--continue-on-error"), &stdout, &stderr)
+	if got := exitCode(err); got != exitPartial {
+		t.Errorf("exit code %d (%v), want %d", got, err, exitPartial)
+	}
+	if stdout.String() != "e1\ne2\n" {
+		t.Errorf("wrote %q, want the events of the calendar that succeeded", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "Unable to retrieve events of shared: googleapi: Error 403: Forbidden") {
+		t.Errorf("stderr is %q, want the failure of shared reported", stderr.String())
+	}
+	if p, ok := err.(partialError); !ok || len(p.failed) != 1 || p.total != 2 {
+		t.Errorf("got error %#v, want one of two calendars failed", err)
+	}
+}
+
+// slowService serves a first page of events, then waits for its context to
+// end before serving another.
+type slowService struct {
+	fakeService
+}
+
+func (s *slowService) ListEvents(ctx context.Context, calendarID string, q eventQuery, fn func(*calendar.Events) error) error {
+	pages := eventPages(4, 2)
+	if err := fn(pages[0]); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(10 * time.Second):
+	}
+	return fn(pages[1])
+}
+
+func TestTimeout(t *testing.T) {
+	defer useService(&slowService{})()
+	_, err := runCommand("--timeout", "50ms", "--start", "2024-01-01", "--end", "2024-01-31")
+	if err == nil || !strings.Contains(err.Error(), "timed out after 50ms with 2 events collected") {
+		t.Errorf("got error %v, want a timeout after collecting 2 events", err)
+	}
+}
+
+// interruptedService serves a first page of events, then interrupts the
+// command as a SIGINT would before serving another.
+type interruptedService struct {
+	fakeService
+	interrupt func()
+}
+
+func (s *interruptedService) ListEvents(ctx context.Context, calendarID string, q eventQuery, fn func(*calendar.Events) error) error {
+	pages := eventPages(4, 2)
+	if err := fn(pages[0]); err != nil {
+		return err
+	}
+	s.interrupt()
+	return fn(pages[1])
+}
+
+func TestInterruptClosesOutput(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer useService(&interruptedService{interrupt: cancel})()
+	path := filepath.Join(dir, "events.json")
+	var stdout, stderr bytes.Buffer
+	err := run(ctx, []string{"--start", "2024-01-01", "--end", "2024-01-31", "--format", "json", "-o", path}, &stdout, &stderr)
+	if err != errInterrupted {
+		t.Fatalf("got error %v, want errInterrupted", err)
+	}
+	if code := exitCode(err); code != exitInterrupted {
+		t.Errorf("exit code %d, want %d", code, exitInterrupted)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []map[string]string
+	if err := json.Unmarshal(b, &events); err != nil {
+		t.Fatalf("output file is not valid JSON: %v\n%s", err, b)
+	}
+	if len(events) != 2 || events[1]["id"] != "e2" {
+		t.Errorf("output file holds %v, want the 2 events of the first page", events)
+	}
+	if !strings.Contains(stderr.String(), "Interrupted after writing 2 events") {
+		t.Errorf("reported %q, want the events written before the interruption", stderr.String())
+	}
+}
+
+func TestIsBrokenPipe(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want bool
+	}{
+		{syscall.EPIPE, true},
+		{&os.PathError{Op: "write", Path: "|1", Err: syscall.EPIPE}, true},
+		{&os.PathError{Op: "write", Path: "events.csv", Err: syscall.ENOSPC}, false},
+		{errors.New("broken pipe"), false},
+		{nil, false},
+	} {
+		if got := isBrokenPipe(c.err); got != c.want {
+			t.Errorf("isBrokenPipe(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestClosedStdout(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	// The reader exits before the events are written, as head does.
+	r.Close()
+	srv := &fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(3, 1)}}
+	defer useService(srv)()
+	var stderr bytes.Buffer
+	err = run(context.Background(), []string{"--start", "2024-01-01", "--end", "2024-01-31"}, w, &stderr)
+	if code := exitCode(err); code != exitOK {
+		t.Errorf("exit code %d (%v), want %d", code, err, exitOK)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("reported %q, want nothing", stderr.String())
+	}
+}
+
+func TestOutputFile(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	srv := &fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(3, 2)}}
+	defer useService(srv)()
+	args := []string{"--start", "2024-01-01", "--end", "2024-01-31", "--format", "ics"}
+	want, err := runCommand(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "events.ics")
+	out, err := runCommand(append(args, "-o", path)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "" {
+		t.Errorf("wrote %q to stdout with --output", out)
+	}
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The stamps of events without an update time are the time of writing.
+	stamp := regexp.MustCompile(`DTSTAMP:\d{8}T\d{6}Z`)
+	if stamp.ReplaceAllString(string(got), "") != stamp.ReplaceAllString(want, "") {
+		t.Errorf("output file holds:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestAppendOutput(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "events.csv")
+	args := []string{"--start", "2024-01-01", "--end", "2024-01-31", "--fields", "id,summary", "-o", path, "--append"}
+
+	// An empty file gets the header row.
+	defer useService(serviceWith(timedEvent("first", "2024-01-15T09:00:00Z", time.Hour)))()
+	if _, err := runCommand(args...); err != nil {
+		t.Fatal(err)
+	}
+	defer useService(serviceWith(timedEvent("second", "2024-01-16T09:00:00Z", time.Hour)))()
+	if _, err := runCommand(args...); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "id,summary\nfirst,Event first\nsecond,Event second\n"; string(got) != want {
+		t.Errorf("output file holds %q, want %q with one header row", got, want)
+	}
+	if _, err := runCommand("--start", "2024-01-01", "--end", "2024-01-31", "--format", "json", "-o", path, "--append"); err == nil {
+		t.Error("--append with --format json succeeded")
+	}
+}
+
+func TestGzipOutput(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	srv := &fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(3, 2)}}
+	defer useService(srv)()
+	args := []string{"--start", "2024-01-01", "--end", "2024-01-31", "--format", "json"}
+	want, err := runCommand(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCommand(append(args, "-o", filepath.Join(dir, "events.json"), "--gzip")...); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(dir, "events.json.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("archive is truncated: %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("archive holds:\n%s\nwant:\n%s", got, want)
+	}
+	if _, err := runCommand(append(args, "--gzip")...); err == nil {
+		t.Error("--gzip without --output succeeded")
+	}
+}
+
+func TestCountsReported(t *testing.T) {
+	defer useService(&fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(5, 2)}})()
+	var stdout, stderr bytes.Buffer
+	if err := run(context.Background(), []string{"--start", "2024-01-01", "--end", "2024-01-31"}, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if got := stderr.String(); !strings.Contains(got, "fetched 3 pages, 5 events") {
+		t.Errorf("reported %q, want the page and event counts", got)
+	}
+	stderr.Reset()
+	if err := run(context.Background(), []string{"-q", "--start", "2024-01-01", "--end", "2024-01-31"}, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if stderr.Len() > 0 {
+		t.Errorf("reported %q with --quiet", stderr.String())
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	saved := newCalendarService
+	defer func() { newCalendarService = saved }()
+	newCalendarService = func(ctx context.Context, auth *authFlags, fs *flag.FlagSet, stderr io.Writer, retry retryPolicy) (CalendarService, error) {
+		t.Fatal("connected to the API with --dry-run")
+		return nil, nil
+	}
+	var stdout, stderr bytes.Buffer
+	args := []string{"--dry-run", "--calendar", "primary,team", "--start", "2024-01-01T00:00:00Z", "--end", "2024-01-08T00:00:00Z",
+		"--limit", "50", "--order-by", "updated", "--query", "standup"}
+	if err := run(context.Background(), args, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.Len() > 0 {
+		t.Errorf("wrote %q to stdout with --dry-run", stdout.String())
+	}
+	want := `events primary: showDeleted=false singleEvents=true maxResults=50 timeMin=2024-01-01T00:00:00Z timeMax=2024-01-08T00:00:00Z orderBy=updated q="standup"
+events team: showDeleted=false singleEvents=true maxResults=50 timeMin=2024-01-01T00:00:00Z timeMax=2024-01-08T00:00:00Z orderBy=updated q="standup"
+format: csv
+fields: ` + defaultFields + `,calendar
+`
+	if got := stderr.String(); got != want {
+		t.Errorf("printed:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// Prints the calendars the authorized user can access.
+func listCalendars(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("calendar list-calendars", flag.ContinueOnError)
+	var auth authFlags
+	auth.register(fs)
+	if err := parseFlags(fs, args, stderr); err != nil {
+		return err
+	}
+
+	srv, err := connect(ctx, &auth, fs, stderr, retryPolicy{})
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSUMMARY\tACCESS ROLE\tPRIMARY")
+	err = srv.ListCalendars(ctx, func(l *calendar.CalendarList) error {
+		for _, item := range l.Items {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\n", item.Id, item.Summary, item.AccessRole, item.Primary)
+		}
+		return nil
+	})
+	if isUnauthorized(err) {
+		return auth.rejected(err)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to retrieve calendars: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("unable to write calendars: %v", err)
+	}
+	return nil
+}
+
+// Returns the ID of the calendar whose summary is name, ignoring case. It is
+// an error when no calendar or more than one has that name.
+func calendarByName(ctx context.Context, c CalendarLister, name string) (string, error) {
+	var summaries, ids []string
+	err := c.ListCalendars(ctx, func(l *calendar.CalendarList) error {
+		for _, item := range l.Items {
+			summaries = append(summaries, fmt.Sprintf("%q", item.Summary))
+			if strings.EqualFold(item.Summary, name) {
+				ids = append(ids, item.Id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("no calendar is named %q, expected one of %s", name, strings.Join(summaries, ", "))
+	case 1:
+		return ids[0], nil
+	}
+	return "", fmt.Errorf("%d calendars are named %q, pass one of their IDs with --calendar: %s", len(ids), name, strings.Join(ids, ", "))
+}
+
+// Looks up the time zone calendarID is configured with, for converting the
+// events of every calendar listed, so it is fetched once per run.
+func calendarTimezone(ctx context.Context, c CalendarLister, calendarID string) (*time.Location, error) {
+	entry, err := c.GetCalendar(ctx, calendarID)
+	if err != nil {
+		return nil, err
+	}
+	if entry.TimeZone == "" {
+		return nil, fmt.Errorf("calendar %q has no time zone", calendarID)
+	}
+	loc, err := time.LoadLocation(entry.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q of calendar %q: %v", entry.TimeZone, calendarID, err)
+	}
+	return loc, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// Names the Calendar web interface gives the event colors, by color ID. The
+// API only returns their hex values.
+var eventColorNames = map[string]string{
+	"1":  "Lavender",
+	"2":  "Sage",
+	"3":  "Grape",
+	"4":  "Flamingo",
+	"5":  "Banana",
+	"6":  "Tangerine",
+	"7":  "Peacock",
+	"8":  "Graphite",
+	"9":  "Blueberry",
+	"10": "Basil",
+	"11": "Tomato",
+}
+
+// Fetches the event color palette once and returns the description of each
+// color ID, as the name and background hex value, like "Tomato #dc2127".
+func eventColors(ctx context.Context, c ColorGetter) (map[string]string, error) {
+	palette, err := c.GetColors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	colors := map[string]string{}
+	for id, def := range palette.Event {
+		colors[id] = strings.TrimSpace(eventColorNames[id] + " " + def.Background)
+	}
+	return colors, nil
+}
+
+// Fetches the palette into opts when the color field is written, so that it
+// shows color names instead of IDs.
+func loadColors(ctx context.Context, c ColorGetter, opts *formatOptions) error {
+	if !hasField(opts.fields, "color") {
+		return nil
+	}
+	var err error
+	opts.colors, err = eventColors(ctx, c)
+	return err
+}
+
+// Returns the color of item described by colors, the color ID when colors
+// does not know it, or "" when the event has the calendar's color.
+func eventColor(item *Event, o *fieldOptions) string {
+	if item.ColorId == "" {
+		return ""
+	}
+	if c, ok := o.colors[item.ColorId]; ok {
+		return c
+	}
+	return item.ColorId
+}
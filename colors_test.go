@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+func TestColorField(t *testing.T) {
+	page := eventPages(3, 10)[0]
+	page.Items[0].ColorId = "11"
+	page.Items[2].ColorId = "99"
+	srv := &fakeService{
+		pages: map[string][]*calendar.Events{"primary": {page}},
+		colors: &calendar.Colors{Event: map[string]calendar.ColorDefinition{
+			"11": {Background: "#dc2127", Foreground: "#1d1d1d"},
+			"12": {Background: "#123456", Foreground: "#1d1d1d"},
+		}},
+	}
+	defer useService(srv)()
+	out, err := runCommand("--start", "2024-01-01", "--end", "2024-01-31", "--fields", "id,color", "--no-header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"e1,Tomato #dc2127", "e2,", "e3,99"}
+	if got := lines(out); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+	if srv.colorFetches != 1 {
+		t.Errorf("fetched the colors %d times, want once", srv.colorFetches)
+	}
+	if _, err := runCommand("--start", "2024-01-01", "--end", "2024-01-31"); err != nil {
+		t.Fatal(err)
+	}
+	if srv.colorFetches != 1 {
+		t.Error("fetched the colors without the color field")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config file read when --config is not given. It is optional.
+var defaultConfigPath = "~/.config/calendar/config.yaml"
+
+// Reads the YAML config file at path, a mapping from flag names to values,
+// and sets every flag of fs that was not given explicitly. Lists set
+// repeatable flags once per item. Keys naming flags of other commands are
+// ignored. A missing file is only an error when required.
+func applyConfig(fs *flag.FlagSet, path string, required bool) error {
+	path, err := expandHome(path)
+	if err != nil {
+		return fmt.Errorf("unable to resolve config path: %v", err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read config file: %v", err)
+	}
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return fmt.Errorf("unable to parse config file %s: %v", path, err)
+	}
+	explicit := visited(fs)
+	for name, value := range config {
+		if explicit[name] || name == "config" || fs.Lookup(name) == nil {
+			continue
+		}
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		for _, v := range values {
+			if err := fs.Set(name, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("invalid value for %s in config file %s: %v", name, path, err)
+			}
+		}
+	}
+	return nil
+}
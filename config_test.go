@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// Points the default config file at one that does not exist, so the config
+// of whoever runs the tests does not change their flags.
+func TestMain(m *testing.M) {
+	defaultConfigPath = filepath.Join("testdata", "no-config.yaml")
+	os.Exit(m.Run())
+}
+
+// Writes the YAML config to a file in dir and returns its path.
+func writeConfig(t *testing.T, dir, config string) string {
+	t.Helper()
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := writeConfig(t, dir, "limit: 3\nformat: json\ncalendar: [a, b]\nunknown-flag: ignored\n")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	limit := fs.Int("limit", 250, "")
+	format := fs.String("format", "csv", "")
+	var calendars stringList
+	fs.Var(&calendars, "calendar", "")
+	if err := fs.Parse([]string{"--limit", "5"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(fs, path, true); err != nil {
+		t.Fatal(err)
+	}
+	if *limit != 5 {
+		t.Errorf("limit = %d, want the command line's 5", *limit)
+	}
+	if *format != "json" {
+		t.Errorf("format = %q, want the config file's json", *format)
+	}
+	if len(calendars) != 2 || calendars[0] != "a" || calendars[1] != "b" {
+		t.Errorf("calendars = %q, want a and b from the config file", calendars)
+	}
+}
+
+func TestConfigFileFlags(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := writeConfig(t, dir, "fields: start\nno-header: true\n")
+	srv := &fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(2, 10)}}
+	defer useService(srv)()
+	out, err := runCommand("--config", path, "--start", "2024-01-01", "--end", "2024-01-31", "--fields", "summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// --fields beats the config file, whose no-header beats the default.
+	if got := lines(out); len(got) != 2 || got[0] != "Event e1" || got[1] != "Event e2" {
+		t.Errorf("wrote %q, want the summaries of e1 and e2 without a header", got)
+	}
+}
+
+func TestConfigMissing(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	path := filepath.Join(dir, "missing.yaml")
+	if err := applyConfig(fs, path, false); err != nil {
+		t.Errorf("missing default config file: %v", err)
+	}
+	if err := applyConfig(fs, path, true); err == nil {
+		t.Error("missing --config file was not reported")
+	}
+}
+
+func TestConfigInvalidValue(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := writeConfig(t, dir, "limit: lots\n")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("limit", 250, "")
+	if err := applyConfig(fs, path, true); err == nil {
+		t.Error("invalid limit in the config file was accepted")
+	}
+}
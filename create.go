@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// eventInput holds the flags describing an event to create.
+type eventInput struct {
+	summary     string
+	start       string
+	end         string
+	duration    time.Duration
+	location    string
+	description string
+}
+
+// Builds the event described by in, resolving relative dates against now.
+// The event lasts until end when it is set, or for duration otherwise.
+func (in eventInput) event(now time.Time) (*calendar.Event, error) {
+	if in.summary == "" {
+		return nil, errors.New("--summary is required")
+	}
+	if in.start == "" {
+		return nil, errors.New("--start is required")
+	}
+	start, err := parseWhen(in.start, now, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse start date: %v", err)
+	}
+	end := start.Add(in.duration)
+	if in.end != "" {
+		if end, err = parseWhen(in.end, now, true); err != nil {
+			return nil, fmt.Errorf("unable to parse end date: %v", err)
+		}
+	}
+	if !end.After(start) {
+		return nil, fmt.Errorf("end date must be after start date: %s -> %s", start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+	return &calendar.Event{
+		Summary:     in.summary,
+		Location:    in.location,
+		Description: in.description,
+		Start:       &calendar.EventDateTime{DateTime: start.Format(time.RFC3339)},
+		End:         &calendar.EventDateTime{DateTime: end.Format(time.RFC3339)},
+	}, nil
+}
+
+// Creates an event and prints its link.
+func createEvent(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("calendar create", flag.ContinueOnError)
+	var auth authFlags
+	var in eventInput
+	var calendarID string
+	auth.register(fs)
+	fs.StringVar(&calendarID, "calendar", "primary", "Calendar ID to create the event in")
+	fs.StringVar(&in.summary, "summary", "", "Title of the event")
+	fs.StringVar(&in.start, "start", "", "Start time: RFC3339 [2006-01-02T15:04:05Z], date [2006-01-02] or keyword like today, tomorrow")
+	fs.StringVar(&in.end, "end", "", "End time, in the same forms as --start (default start plus --duration)")
+	fs.DurationVar(&in.duration, "duration", time.Hour, "Length of the event when --end is not given")
+	fs.StringVar(&in.location, "location", "", "Location of the event")
+	fs.StringVar(&in.description, "description", "", "Description of the event")
+	if err := parseFlags(fs, args, stderr); err != nil {
+		return err
+	}
+	if in.end != "" && visited(fs)["duration"] {
+		return errors.New("--end cannot be combined with --duration")
+	}
+	item, err := in.event(timeNow())
+	if err != nil {
+		return err
+	}
+
+	auth.writes = true
+	srv, err := connect(ctx, &auth, fs, stderr, retryPolicy{})
+	if err != nil {
+		return err
+	}
+	created, err := srv.InsertEvent(ctx, calendarID, item)
+	if isUnauthorized(err) {
+		return auth.rejected(err)
+	}
+	if isForbidden(err) {
+		return authError{fmt.Errorf("not allowed to create events in calendar %q, check that you can edit it: %v", calendarID, err)}
+	}
+	if err != nil {
+		return fmt.Errorf("unable to create event: %v", err)
+	}
+	fmt.Fprintln(stdout, created.HtmlLink)
+	return nil
+}
+
+// Reports whether err is the API refusing access, like to a calendar the
+// user cannot edit.
+func isForbidden(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == http.StatusForbidden
+}
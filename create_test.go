@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventInputValidation(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		in   eventInput
+		want string
+	}{
+		{"no summary", eventInput{start: "2024-01-15T10:00:00Z", duration: time.Hour}, "--summary is required"},
+		{"no start", eventInput{summary: "x", duration: time.Hour}, "--start is required"},
+		{"bad start", eventInput{summary: "x", start: "someday", duration: time.Hour}, "unable to parse start date"},
+		{"end before start", eventInput{summary: "x", start: "2024-01-15T10:00:00Z", end: "2024-01-15T09:00:00Z"}, "end date must be after start date"},
+		{"end at start", eventInput{summary: "x", start: "2024-01-15T10:00:00Z", end: "2024-01-15T10:00:00Z"}, "end date must be after start date"},
+		{"zero duration", eventInput{summary: "x", start: "2024-01-15T10:00:00Z"}, "end date must be after start date"},
+	} {
+		_, err := c.in.event(testNow)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got error %v, want %q", c.name, err, c.want)
+		}
+	}
+}
+
+func TestEventInput(t *testing.T) {
+	in := eventInput{summary: "Review", start: "2024-01-15T10:00:00Z", duration: 30 * time.Minute, location: "Room 4"}
+	item, err := in.event(testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Summary != "Review" || item.Location != "Room 4" || item.Start.DateTime != "2024-01-15T10:00:00Z" || item.End.DateTime != "2024-01-15T10:30:00Z" {
+		t.Errorf("got event %+v from %+v", item, in)
+	}
+}
+
+func TestCreateCommand(t *testing.T) {
+	srv := &fakeService{}
+	defer useService(srv)()
+	out, err := runCommand("create", "--calendar", "team", "--summary", "Review", "--start", "2024-01-15T10:00:00Z", "--end", "2024-01-15T11:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.inserted["team"]) != 1 || srv.inserted["team"][0].Summary != "Review" {
+		t.Errorf("inserted %v, want the review in team", srv.inserted)
+	}
+	if strings.TrimSpace(out) != "https://calendar.google.com/event?eid=new1" {
+		t.Errorf("printed %q, want the link of the event", out)
+	}
+	if _, err := runCommand("create", "--summary", "x", "--start", "2024-01-15T10:00:00Z", "--end", "2024-01-15T11:00:00Z", "--duration", "1h"); err == nil {
+		t.Error("--end with --duration succeeded")
+	}
+}
+
+func TestCreateRelativeStart(t *testing.T) {
+	defer useClock(time.Date(2024, 1, 15, 9, 30, 0, 0, time.Local))()
+	srv := &fakeService{}
+	defer useService(srv)()
+	if _, err := runCommand("create", "--summary", "Review", "--start", "tomorrow", "--duration", "30m"); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 16, 0, 0, 0, 0, time.Local)
+	if got := srv.inserted["primary"]; len(got) != 1 || got[0].Start.DateTime != start.Format(time.RFC3339) || got[0].End.DateTime != start.Add(30*time.Minute).Format(time.RFC3339) {
+		t.Errorf("inserted %v, want an event at midnight after the clock's day", got)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// Parses a --start/--end value relative to now. Accepts RFC3339 timestamps,
+// dates in 2006-01-02 form, and the keywords now, today, tomorrow, yesterday,
+// this-week, next-week and this-month. Dates and keywords resolve to midnight
+// in the location of now; weeks start on Monday. When end is set, a date
+// includes its whole day and resolves to the following midnight, so that
+// the same date as start and end covers that day.
+func parseWhen(s string, now time.Time, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, now.Location()); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	today := startOfDay(now)
+	switch s {
+	case "now":
+		return now, nil
+	case "today":
+		return today, nil
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	case "this-week":
+		return startOfWeek(today), nil
+	case "next-week":
+		return startOfWeek(today).AddDate(0, 0, 7), nil
+	case "this-month":
+		return time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location()), nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q, expected RFC3339, 2006-01-02 or a keyword", s)
+}
+
+// windowFlags are the flags choosing the time window to list events in.
+type windowFlags struct {
+	start string
+	end   string
+	from  time.Duration
+	to    time.Duration
+	// window, when positive and end is not given, is the length of the
+	// window from the start.
+	window time.Duration
+	// since and until, when start and end are not given, place them
+	// relative to now.
+	since time.Duration
+	until time.Duration
+	// endInclusive includes events starting exactly at an end given as a
+	// timestamp, which the API's exclusive timeMax leaves out.
+	endInclusive bool
+}
+
+func (f *windowFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.start, "start", "", "Start date: RFC3339 [2006-01-02T15:04:05Z], date [2006-01-02] or keyword like today, next-week (default to now)")
+	fs.StringVar(&f.end, "end", "", "End date: RFC3339 [2006-01-02T15:04:05Z], date [2006-01-02] including that whole day, or keyword like today, next-week (default to now)")
+	fs.DurationVar(&f.from, "from", 0, "Widen the window by moving the start date this much earlier")
+	fs.DurationVar(&f.to, "to", 0, "Widen the window by moving the end date this much later")
+	fs.DurationVar(&f.since, "since", 0, "Start this long before now when --start is not given, like 24h")
+	fs.DurationVar(&f.until, "until", 0, "End this long after now when --end is not given, like 48h")
+	fs.DurationVar(&f.window, "window", 0, "Length of the window from the start date when --end is not given, like 168h")
+	fs.BoolVar(&f.endInclusive, "end-inclusive", false, "Include events starting exactly at the --end timestamp, which is otherwise exclusive")
+}
+
+// Resolves the query window from the --start/--end values, or the start and
+// --window, widening it backward from the start by from and forward from the
+// end by to. Without --start or --end, --since and --until place them
+// relative to now.
+func (f windowFlags) resolve(now time.Time) (start, end time.Time, err error) {
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{{"window", f.window}, {"since", f.since}, {"until", f.until}} {
+		if d.value < 0 {
+			return start, end, fmt.Errorf("--%s must be zero or positive, not %v", d.name, d.value)
+		}
+	}
+	if f.window > 0 && (f.end != "" || f.until > 0) {
+		return start, end, errors.New("--window cannot be combined with --end or --until")
+	}
+	start, end = now.Add(-f.since), now.Add(f.until)
+	if f.start != "" {
+		start, err = parseWhen(f.start, now, false)
+		if err != nil {
+			return start, end, fmt.Errorf("unable to parse start date: %v", err)
+		}
+	}
+	if f.endInclusive {
+		// A date or keyword already ends after the last moment it names.
+		if _, err := time.Parse(time.RFC3339, f.end); err != nil {
+			return start, end, errors.New("--end-inclusive requires an RFC3339 --end, like 2024-01-31T23:59:59Z")
+		}
+	}
+	if f.end != "" {
+		end, err = parseWhen(f.end, now, true)
+		if err != nil {
+			return start, end, fmt.Errorf("unable to parse end date: %v", err)
+		}
+		if f.endInclusive {
+			// The API takes times to the second, so the next second is
+			// the earliest end after the one given.
+			end = end.Add(time.Second)
+		}
+	} else if f.window > 0 {
+		end = start.Add(f.window)
+	}
+	return start.Add(-f.from), end.Add(f.to), nil
+}
+
+// Returns midnight at the start of t's day.
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// Returns midnight on the Monday of t's week.
+func startOfWeek(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	return startOfDay(t).AddDate(0, 0, -offset)
+}
+
+// Rewrites a timed start or end in loc. All-day dates are left untouched.
+func convertEventTime(t *calendar.EventDateTime, loc *time.Location) {
+	if t == nil || t.DateTime == "" {
+		return
+	}
+	dt, err := time.Parse(time.RFC3339, t.DateTime)
+	if err != nil {
+		return
+	}
+	t.DateTime = dt.In(loc).Format(time.RFC3339)
+	t.TimeZone = loc.String()
+}
+
+// Returns a copy of t rewritten in loc by convertEventTime, leaving t as it
+// is, or nil when t is nil.
+func convertedEventTime(t *calendar.EventDateTime, loc *time.Location) *calendar.EventDateTime {
+	if t == nil {
+		return nil
+	}
+	converted := *t
+	convertEventTime(&converted, loc)
+	return &converted
+}
+
+// Named --date-format layouts.
+var dateLayouts = map[string]string{
+	"rfc3339": time.RFC3339,
+	"kitchen": time.Kitchen,
+	"date":    "2006-01-02",
+}
+
+// Returns the layout for a --date-format value, either a preset name or a Go
+// reference layout, which must mention at least one element of the reference
+// time.
+func parseDateLayout(s string) (string, error) {
+	if layout, ok := dateLayouts[s]; ok {
+		return layout, nil
+	}
+	// A layout without any element formats every time as itself.
+	if time.Date(1999, 12, 31, 23, 59, 58, 0, time.UTC).Format(s) == s {
+		return "", fmt.Errorf("invalid date format %q, expected a Go layout like \"Mon Jan 2 3:04pm\" or one of date, kitchen, rfc3339", s)
+	}
+	return s, nil
+}
+
+// Elements of a layout that show the time of day rather than the date.
+var timeLayoutElements = []string{"15", "03", "3", "04", "05", "PM", "pm", "MST", "Z07", "-07"}
+
+// Returns the date part of layout for all-day events: the layout up to its
+// first time of day element, or 2006-01-02 when it starts with the time.
+func dateOnlyLayout(layout string) string {
+	cut := len(layout)
+	for _, e := range timeLayoutElements {
+		if i := strings.Index(layout, e); i >= 0 && i < cut {
+			cut = i
+		}
+	}
+	if date := strings.TrimRight(layout[:cut], " ,T@"); date != "" {
+		return date
+	}
+	return "2006-01-02"
+}
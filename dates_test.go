@@ -0,0 +1,247 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// A Wednesday afternoon in a zone east of UTC, so that local midnights
+// differ from UTC ones.
+var testNow = time.Date(2024, 1, 17, 15, 4, 5, 0, time.FixedZone("UTC+2", 2*60*60))
+
+// Returns midnight on the given day in the zone of testNow.
+func localDate(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, testNow.Location())
+}
+
+func TestParseWhen(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want time.Time
+	}{
+		{"2024-02-03T04:05:06Z", time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)},
+		{"2024-02-03", localDate(2024, 2, 3)},
+		{"now", testNow},
+		{"today", localDate(2024, 1, 17)},
+		{"tomorrow", localDate(2024, 1, 18)},
+		{"yesterday", localDate(2024, 1, 16)},
+		{"this-week", localDate(2024, 1, 15)},
+		{"next-week", localDate(2024, 1, 22)},
+		{"this-month", localDate(2024, 1, 1)},
+	} {
+		got, err := parseWhen(c.s, testNow, false)
+		if err != nil {
+			t.Errorf("%s: %v", c.s, err)
+			continue
+		}
+		if !got.Equal(c.want) {
+			t.Errorf("%s = %v, want %v", c.s, got, c.want)
+		}
+	}
+}
+
+func TestParseWhenWeekStartsMonday(t *testing.T) {
+	sunday := time.Date(2024, 1, 21, 23, 0, 0, 0, testNow.Location())
+	got, err := parseWhen("this-week", sunday, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := localDate(2024, 1, 15); !got.Equal(want) {
+		t.Errorf("this-week on a Sunday = %v, want %v", got, want)
+	}
+}
+
+func TestParseWhenInvalid(t *testing.T) {
+	for _, s := range []string{"", "someday", "2024-13-01", "17/01/2024"} {
+		if got, err := parseWhen(s, testNow, false); err == nil {
+			t.Errorf("%q parsed as %v", s, got)
+		}
+	}
+}
+
+func TestWindowWidening(t *testing.T) {
+	start := "2024-01-10T09:00:00Z"
+	end := "2024-01-12T09:00:00Z"
+	utc := func(day, hour int) time.Time { return time.Date(2024, 1, day, hour, 0, 0, 0, time.UTC) }
+	for _, c := range []struct {
+		name      string
+		flags     windowFlags
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{"plain", windowFlags{start: start, end: end}, utc(10, 9), utc(12, 9)},
+		{"from", windowFlags{start: start, end: end, from: 3 * time.Hour}, utc(10, 6), utc(12, 9)},
+		{"to", windowFlags{start: start, end: end, to: 3 * time.Hour}, utc(10, 9), utc(12, 12)},
+		{"both", windowFlags{start: start, end: end, from: 24 * time.Hour, to: 48 * time.Hour}, utc(9, 9), utc(14, 9)},
+		{"to without end", windowFlags{start: start, to: time.Hour}, utc(10, 9), testNow.Add(time.Hour)},
+	} {
+		gotStart, gotEnd, err := c.flags.resolve(testNow)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !gotStart.Equal(c.wantStart) || !gotEnd.Equal(c.wantEnd) {
+			t.Errorf("%s: window %v to %v, want %v to %v", c.name, gotStart, gotEnd, c.wantStart, c.wantEnd)
+		}
+	}
+}
+
+func TestConvertEventTime(t *testing.T) {
+	for _, c := range []struct {
+		zone string
+		want string
+	}{
+		{"America/New_York", "2024-01-15T09:30:00-05:00"},
+		{"Asia/Kolkata", "2024-01-15T20:00:00+05:30"},
+		{"UTC", "2024-01-15T14:30:00Z"},
+	} {
+		loc, err := time.LoadLocation(c.zone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dt := &calendar.EventDateTime{DateTime: "2024-01-15T14:30:00Z"}
+		convertEventTime(dt, loc)
+		if dt.DateTime != c.want || dt.TimeZone != c.zone {
+			t.Errorf("in %s got %s %s, want %s", c.zone, dt.DateTime, dt.TimeZone, c.want)
+		}
+	}
+}
+
+func TestConvertEventTimeLeavesDates(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dt := &calendar.EventDateTime{Date: "2024-01-15"}
+	convertEventTime(dt, loc)
+	if dt.Date != "2024-01-15" || dt.DateTime != "" || dt.TimeZone != "" {
+		t.Errorf("all-day date converted to %+v", dt)
+	}
+	convertEventTime(nil, loc)
+}
+
+func TestUnknownTimezone(t *testing.T) {
+	_, err := runCommand("--timezone", "Mars/Olympus_Mons", "--start", "2024-01-01", "--end", "2024-01-31")
+	if err == nil || !strings.Contains(err.Error(), `unknown timezone "Mars/Olympus_Mons"`) {
+		t.Errorf("got error %v, want unknown timezone", err)
+	}
+}
+
+func TestDateOnlyBounds(t *testing.T) {
+	start, err := parseWhen("2024-03-05", testNow, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := localDate(2024, 3, 5); !start.Equal(want) {
+		t.Errorf("start date = %v, want %v", start, want)
+	}
+	end, err := parseWhen("2024-03-05", testNow, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := localDate(2024, 3, 6); !end.Equal(want) {
+		t.Errorf("end date = %v, want the following midnight %v", end, want)
+	}
+	// Timestamps are used as given for either bound.
+	ts, err := parseWhen("2024-03-05T12:00:00Z", testNow, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC); !ts.Equal(want) {
+		t.Errorf("end timestamp = %v, want %v", ts, want)
+	}
+}
+
+func TestSameDateCoversTheDay(t *testing.T) {
+	start, end, err := windowFlags{start: "2024-03-05", end: "2024-03-05"}.resolve(testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end.Sub(start) != 24*time.Hour {
+		t.Errorf("window %v to %v, want the whole day", start, end)
+	}
+}
+
+func TestWindowLength(t *testing.T) {
+	start, end, err := windowFlags{start: "2024-03-05", window: 7 * 24 * time.Hour}.resolve(testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := localDate(2024, 3, 12); !start.Equal(localDate(2024, 3, 5)) || !end.Equal(want) {
+		t.Errorf("window %v to %v, want a week from the start", start, end)
+	}
+	for _, f := range []windowFlags{
+		{start: "2024-03-05", end: "2024-03-06", window: time.Hour},
+		{start: "2024-03-05", until: time.Hour, window: time.Hour},
+		{start: "2024-03-05", window: -time.Hour},
+	} {
+		if _, _, err := f.resolve(testNow); err == nil {
+			t.Errorf("%+v was accepted", f)
+		}
+	}
+}
+
+func TestSinceAndUntil(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		flags     windowFlags
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{"since", windowFlags{since: 24 * time.Hour}, testNow.Add(-24 * time.Hour), testNow},
+		{"until", windowFlags{until: 48 * time.Hour}, testNow, testNow.Add(48 * time.Hour)},
+		{"both", windowFlags{since: time.Hour, until: 2 * time.Hour}, testNow.Add(-time.Hour), testNow.Add(2 * time.Hour)},
+		{"explicit start wins", windowFlags{start: "2024-01-10", since: time.Hour, until: time.Hour}, localDate(2024, 1, 10), testNow.Add(time.Hour)},
+		{"explicit end wins", windowFlags{end: "2024-01-20", since: time.Hour, until: time.Hour}, testNow.Add(-time.Hour), localDate(2024, 1, 21)},
+	} {
+		start, end, err := c.flags.resolve(testNow)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !start.Equal(c.wantStart) || !end.Equal(c.wantEnd) {
+			t.Errorf("%s: window %v to %v, want %v to %v", c.name, start, end, c.wantStart, c.wantEnd)
+		}
+	}
+	if _, _, err := (windowFlags{since: -time.Hour}).resolve(testNow); err == nil {
+		t.Error("negative --since was accepted")
+	}
+	if _, _, err := (windowFlags{since: 0, until: time.Hour}).resolve(testNow); err != nil {
+		t.Errorf("zero --since: %v", err)
+	}
+}
+
+func TestEndInclusive(t *testing.T) {
+	boundary := timedEvent("boundary", "2024-01-31T23:59:59Z", time.Hour)
+	for _, c := range []struct {
+		flags   windowFlags
+		listed  bool
+		timeMax string
+	}{
+		{windowFlags{start: "2024-01-01", end: "2024-01-31T23:59:59Z"}, false, "2024-01-31T23:59:59Z"},
+		{windowFlags{start: "2024-01-01", end: "2024-01-31T23:59:59Z", endInclusive: true}, true, "2024-02-01T00:00:00Z"},
+	} {
+		_, end, err := c.flags.resolve(testNow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := end.Format(time.RFC3339); got != c.timeMax {
+			t.Errorf("%+v: timeMax %s, want %s", c.flags, got, c.timeMax)
+		}
+		// The API lists the events starting before timeMax.
+		if listed := eventStart(&Event{Event: boundary}).Before(end); listed != c.listed {
+			t.Errorf("%+v: event at the end listed %v, want %v", c.flags, listed, c.listed)
+		}
+	}
+	for _, f := range []windowFlags{
+		{start: "2024-01-01", end: "2024-01-31", endInclusive: true},
+		{start: "2024-01-01", window: time.Hour, endInclusive: true},
+	} {
+		if _, _, err := f.resolve(testNow); err == nil {
+			t.Errorf("%+v was accepted", f)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Deletes an event by ID after asking for confirmation.
+func deleteEvent(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("calendar delete", flag.ContinueOnError)
+	var auth authFlags
+	var calendarID string
+	var eventID string
+	var yes bool
+	auth.register(fs)
+	fs.StringVar(&calendarID, "calendar", "primary", "Calendar ID to delete the event from")
+	fs.StringVar(&eventID, "id", "", "ID of the event to delete, see the id field when listing events")
+	fs.BoolVar(&yes, "yes", false, "Delete without asking for confirmation")
+	if err := parseFlags(fs, args, stderr); err != nil {
+		return err
+	}
+	if eventID == "" {
+		return errors.New("--id is required")
+	}
+	if !yes {
+		ok, err := confirm(os.Stdin, stderr, fmt.Sprintf("Delete event %s from calendar %s?", eventID, calendarID))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			infof("Not deleted")
+			return nil
+		}
+	}
+
+	auth.readWrite = true
+	srv, err := connect(ctx, &auth, fs, stderr, retryPolicy{})
+	if err != nil {
+		return err
+	}
+	err = srv.DeleteEvent(ctx, calendarID, eventID)
+	if isNotFound(err) || isGone(err) {
+		infof("Event %s was not found or is already deleted", eventID)
+		return nil
+	}
+	if isUnauthorized(err) {
+		return auth.rejected(err)
+	}
+	if isForbidden(err) {
+		return authError{fmt.Errorf("not allowed to delete events in calendar %q, check that you can edit it: %v", calendarID, err)}
+	}
+	if err != nil {
+		return fmt.Errorf("unable to delete event: %v", err)
+	}
+	infof("Deleted event %s", eventID)
+	return nil
+}
+
+// Asks question on w and reports whether the answer read from r is yes.
+func confirm(r io.Reader, w io.Writer, question string) (bool, error) {
+	fmt.Fprintf(w, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("unable to read confirmation: %v", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
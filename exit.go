@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Exit codes of the calendar command.
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitAuth     = 2
+	exitNoEvents = 3
+	exitPartial  = 4
+	// exitInterrupted follows the shell convention of 128 plus SIGINT.
+	exitInterrupted = 130
+)
+
+// errNoEvents is returned when --fail-on-empty is given and nothing was listed.
+var errNoEvents = errors.New("no events found")
+
+// errInterrupted is returned when a signal stopped the command early.
+var errInterrupted = errors.New("interrupted")
+
+// authError marks failures to authorize, like missing credentials or a
+// rejected token.
+type authError struct {
+	err error
+}
+
+func (e authError) Error() string {
+	return e.err.Error()
+}
+
+// Returns the process exit code for the error returned by run.
+func exitCode(err error) int {
+	switch err.(type) {
+	case nil:
+		return exitOK
+	case authError:
+		return exitAuth
+	case partialError:
+		return exitPartial
+	}
+	switch err {
+	case flag.ErrHelp:
+		return exitOK
+	case errNoEvents:
+		return exitNoEvents
+	case errInterrupted:
+		return exitInterrupted
+	}
+	return exitError
+}
+
+// Reports whether err is the API rejecting the credentials, or lists such
+// an error among the failures of several calendars.
+func isUnauthorized(err error) bool {
+	switch e := err.(type) {
+	case multiError:
+		for _, err := range e {
+			if isUnauthorized(err) {
+				return true
+			}
+		}
+		return false
+	case calendarError:
+		return isUnauthorized(e.err)
+	}
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == http.StatusUnauthorized
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Returns the path of a token file in a new temporary directory, and a
+// function removing the directory.
+func tempToken(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, cleanup := tempDir(t)
+	path := filepath.Join(dir, "token.json")
+	if err := ioutil.WriteFile(path, []byte(`{"access_token":"stale"}`), 0600); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return path, cleanup
+}
+
+func TestUnauthorizedAcrossCalendars(t *testing.T) {
+	token, cleanup := tempToken(t)
+	defer cleanup()
+	srv := &fakeService{
+		pages: map[string][]*calendar.Events{"a": eventPages(2, 10)},
+		errs:  map[string]error{"b": &googleapi.Error{Code: http.StatusUnauthorized}},
+	}
+	defer useService(srv)()
+	_, err := runCommand("--calendar", "a,b", "--token", token, "--start", "2024-01-01", "--end", "2024-01-31")
+	if got := exitCode(err); got != exitAuth {
+		t.Errorf("exit code %d (%v), want %d", got, err, exitAuth)
+	}
+	if _, statErr := os.Stat(token); !os.IsNotExist(statErr) {
+		t.Error("kept the rejected token")
+	}
+}
+
+func TestIsUnauthorized(t *testing.T) {
+	unauthorized := &googleapi.Error{Code: http.StatusUnauthorized}
+	forbidden := &googleapi.Error{Code: http.StatusForbidden}
+	for _, c := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{unauthorized, true},
+		{forbidden, false},
+		{calendarError{"a", unauthorized}, true},
+		{multiError{calendarError{"a", forbidden}, calendarError{"b", unauthorized}}, true},
+		{multiError{calendarError{"a", forbidden}}, false},
+	} {
+		if got := isUnauthorized(c.err); got != c.want {
+			t.Errorf("isUnauthorized(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestFailOnEmpty(t *testing.T) {
+	defer useService(&fakeService{pages: map[string][]*calendar.Events{"primary": {{}}}})()
+	_, err := runCommand("--fail-on-empty", "--start", "2024-01-01", "--end", "2024-01-31")
+	if got := exitCode(err); got != exitNoEvents {
+		t.Errorf("exit code %d (%v), want %d", got, err, exitNoEvents)
+	}
+	_, err = runCommand("--start", "2024-01-01", "--end", "2024-01-31")
+	if got := exitCode(err); got != exitOK {
+		t.Errorf("without --fail-on-empty exit code %d (%v), want %d", got, err, exitOK)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want int
+	}{
+		{nil, exitOK},
+		{errors.New("boom"), exitError},
+		{authError{errors.New("no token")}, exitAuth},
+		{errNoEvents, exitNoEvents},
+	} {
+		if got := exitCode(c.err); got != c.want {
+			t.Errorf("exitCode(%v) = %d, want %d", c.err, got, c.want)
+		}
+	}
+}
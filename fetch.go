@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// Default maximum number of calendars fetched at the same time.
+const fetchWorkers = 4
+
+// Values accepted by --order-by.
+const (
+	orderStartTime = "startTime"
+	orderUpdated   = "updated"
+	orderNone      = "none"
+)
+
+// Validates the --order-by value. The API only orders by start time when
+// recurring events are expanded into instances.
+func checkOrderBy(orderBy string, recurring bool) error {
+	switch orderBy {
+	case orderStartTime:
+		if recurring {
+			return errors.New("--order-by startTime requires --expand-recurring")
+		}
+	case orderUpdated, orderNone:
+	default:
+		return fmt.Errorf("unknown order %q, expected startTime, updated or none", orderBy)
+	}
+	return nil
+}
+
+// Parameters of an events list request.
+type eventQuery struct {
+	timeMin    time.Time
+	timeMax    time.Time
+	maxResults int64
+	// showDeleted includes cancelled events.
+	showDeleted bool
+	// recurring returns recurring events once with their recurrence rules
+	// instead of expanding them into instances.
+	recurring bool
+	// orderBy is startTime, updated or none.
+	orderBy string
+	// text is a free text search over summary, description, location,
+	// attendees and other fields.
+	text string
+	// updatedMin, when set, leaves out events last modified before it.
+	updatedMin time.Time
+	// properties are key=value private extended properties events must
+	// all have.
+	properties []string
+	// sharedProperties are key=value shared extended properties events
+	// must all have.
+	sharedProperties []string
+	// sync requests an incremental sync, which leaves out the parameters the
+	// API does not allow with a sync token. The first sync has no token and
+	// fetches everything.
+	sync      bool
+	syncToken string
+}
+
+// Builds the list request for calendarID.
+func (q eventQuery) call(srv *calendar.Service, calendarID string) *calendar.EventsListCall {
+	call := srv.Events.List(calendarID).ShowDeleted(q.showDeleted).SingleEvents(!q.recurring).MaxResults(q.maxResults)
+	if q.sync {
+		if q.syncToken != "" {
+			call = call.SyncToken(q.syncToken)
+		}
+		return call
+	}
+	call = call.TimeMin(q.timeMin.Format(time.RFC3339)).TimeMax(q.timeMax.Format(time.RFC3339))
+	if q.orderBy != orderNone {
+		call = call.OrderBy(q.orderBy)
+	}
+	if q.text != "" {
+		call = call.Q(q.text)
+	}
+	if !q.updatedMin.IsZero() {
+		call = call.UpdatedMin(q.updatedMin.Format(time.RFC3339))
+	}
+	if len(q.properties) > 0 {
+		call = call.PrivateExtendedProperty(q.properties...)
+	}
+	if len(q.sharedProperties) > 0 {
+		call = call.SharedExtendedProperty(q.sharedProperties...)
+	}
+	return call
+}
+
+// Returns the parameters call sets, in the same order, as name=value pairs.
+func (q eventQuery) params() []string {
+	params := []string{
+		fmt.Sprintf("showDeleted=%t", q.showDeleted),
+		fmt.Sprintf("singleEvents=%t", !q.recurring),
+		fmt.Sprintf("maxResults=%d", q.maxResults),
+	}
+	if q.sync {
+		if q.syncToken != "" {
+			params = append(params, "syncToken="+q.syncToken)
+		}
+		return params
+	}
+	params = append(params, "timeMin="+q.timeMin.Format(time.RFC3339), "timeMax="+q.timeMax.Format(time.RFC3339))
+	if q.orderBy != orderNone {
+		params = append(params, "orderBy="+q.orderBy)
+	}
+	if q.text != "" {
+		params = append(params, fmt.Sprintf("q=%q", q.text))
+	}
+	if !q.updatedMin.IsZero() {
+		params = append(params, "updatedMin="+q.updatedMin.Format(time.RFC3339))
+	}
+	for _, p := range q.properties {
+		params = append(params, "privateExtendedProperty="+p)
+	}
+	for _, p := range q.sharedProperties {
+		params = append(params, "sharedExtendedProperty="+p)
+	}
+	return params
+}
+
+// Pages through the events of calendarID, passing each page to fn. Stopping
+// early because the limit or page cap was reached is not an error.
+func fetchEvents(ctx context.Context, lister EventLister, calendarID string, q eventQuery, fn func(*calendar.Events) error) error {
+	err := lister.ListEvents(ctx, calendarID, q, fn)
+	if err == errLimitReached || err == errMaxPages {
+		return nil
+	}
+	if isNotFound(err) {
+		return fmt.Errorf("calendar %q not found or not accessible", calendarID)
+	}
+	return err
+}
+
+// Fetches events from up to workers calendars at a time with copies of base
+// and returns them merged in the order of the query, truncated to the limit
+// of base when it is positive. The pages fetched are added to the page
+// counter of base, and base is marked truncated when any calendar was. Unless
+// keepGoing is set, the first calendar to fail cancels the others. The error
+// lists the failures other than those cancellations, while the events of the
+// calendars that completed are still returned.
+func fetchMerged(ctx context.Context, lister EventLister, calendarIDs []string, q eventQuery, base *EventCollector, workers int, keepGoing bool) ([]*Event, error) {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	buffers := make([]eventBuffer, len(calendarIDs))
+	collectors := make([]EventCollector, len(calendarIDs))
+	errs := make([]error, len(calendarIDs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(calendarIDs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if ctx.Err() != nil {
+					errs[i] = ctx.Err()
+					continue
+				}
+				collectors[i] = *base
+				collectors[i].calendar = calendarIDs[i]
+				errs[i] = fetchEvents(ctx, lister, calendarIDs[i], q, collectors[i].WriteCallback(ctx, &buffers[i]))
+				if errs[i] != nil && !keepGoing {
+					cancel()
+				}
+			}
+		}()
+	}
+	for i := range calendarIDs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var failed multiError
+	var merged []*Event
+	for i := range calendarIDs {
+		base.pageCounter += collectors[i].pageCounter
+		base.truncated = base.truncated || collectors[i].truncated
+		if isCanceled(errs[i]) {
+			continue
+		}
+		if errs[i] != nil {
+			failed = append(failed, calendarError{calendarIDs[i], errs[i]})
+			continue
+		}
+		merged = append(merged, buffers[i].events...)
+	}
+	if base.dedup {
+		merged = dedupEvents(merged)
+	}
+	switch q.orderBy {
+	case orderStartTime:
+		sort.SliceStable(merged, func(i, j int) bool {
+			return eventStart(merged[i]).Before(eventStart(merged[j]))
+		})
+	case orderUpdated:
+		sort.SliceStable(merged, func(i, j int) bool {
+			return merged[i].Updated < merged[j].Updated
+		})
+	}
+	if base.limit > 0 && len(merged) > base.limit {
+		merged = merged[:base.limit]
+	}
+	if len(failed) > 0 {
+		return merged, failed
+	}
+	return merged, parent.Err()
+}
+
+// Returns events without those having the iCalUID and start of an earlier
+// one, which are the copies of a meeting on the calendars of its other
+// attendees. Instances of a recurring event share its iCalUID, so the start
+// tells them apart. Events without an iCalUID are all kept.
+func dedupEvents(events []*Event) []*Event {
+	seen := map[string]bool{}
+	var kept []*Event
+	for _, item := range events {
+		if item.ICalUID != "" {
+			key := item.ICalUID + " " + eventStart(item).UTC().Format(time.RFC3339)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		kept = append(kept, item)
+	}
+	debugf("dropped %d duplicate events", len(events)-len(kept))
+	return kept
+}
+
+// Reports whether err comes from a canceled context, directly or through a
+// failed HTTP request.
+func isCanceled(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	return err == context.Canceled
+}
+
+// errNoEnd is returned by eventInterval for events without an end time.
+var errNoEnd = errors.New("event has no end")
+
+// Returns the span of e and whether it is an all-day event. All-day events
+// start at midnight local time on their first day and end at midnight after
+// their last day, as the API's end date is exclusive. When e has no end, the
+// start is still returned with errNoEnd.
+func eventInterval(e *calendar.Event) (start, end time.Time, allDay bool, err error) {
+	allDay = e.Start != nil && e.Start.DateTime == "" && e.Start.Date != ""
+	if start, err = parseEventTime(e.Start); err != nil {
+		return time.Time{}, time.Time{}, allDay, fmt.Errorf("invalid start: %v", err)
+	}
+	if e.End == nil {
+		return start, time.Time{}, allDay, errNoEnd
+	}
+	if end, err = parseEventTime(e.End); err != nil {
+		return start, time.Time{}, allDay, fmt.Errorf("invalid end: %v", err)
+	}
+	return start, end, allDay, nil
+}
+
+// Parses the date-time of t, or its date as midnight local time.
+func parseEventTime(t *calendar.EventDateTime) (time.Time, error) {
+	if t == nil {
+		return time.Time{}, errors.New("missing")
+	}
+	if t.DateTime != "" {
+		return time.Parse(time.RFC3339, t.DateTime)
+	}
+	return time.ParseInLocation("2006-01-02", t.Date, time.Local)
+}
+
+// Returns the start of item, or the zero time when it has none.
+func eventStart(item *Event) time.Time {
+	start, _, _, _ := eventInterval(item.Event)
+	return start
+}
+
+// Returns the end of item, or the zero time when it has none.
+func eventEnd(item *Event) time.Time {
+	_, end, _, _ := eventInterval(item.Event)
+	return end
+}
+
+// eventBuffer is a Formatter that keeps events in memory.
+type eventBuffer struct {
+	events []*Event
+}
+
+func (b *eventBuffer) WriteEvent(item *Event) error {
+	b.events = append(b.events, item)
+	return nil
+}
+
+func (b *eventBuffer) Flush() error { return nil }
+
+func (b *eventBuffer) Close() error { return nil }
+
+// calendarError is the failure to fetch the events of a calendar, keeping
+// the original error so callers can still tell what went wrong.
+type calendarError struct {
+	calendar string
+	err      error
+}
+
+func (e calendarError) Error() string {
+	return fmt.Sprintf("%s: %v", e.calendar, e.err)
+}
+
+// partialError reports the calendars that failed when --continue-on-error
+// listed the events of the others, out of total calendars.
+type partialError struct {
+	failed multiError
+	total  int
+}
+
+func (e partialError) Error() string {
+	return fmt.Sprintf("unable to retrieve events of %d of %d calendars", len(e.failed), e.total)
+}
+
+// multiError reports several errors at once.
+type multiError []error
+
+func (m multiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// A flag.Value collecting repeated or comma-separated values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		*l = append(*l, strings.TrimSpace(v))
+	}
+	return nil
+}
+
+// propertyList is a repeatable flag of key=value extended properties.
+type propertyList []string
+
+func (l *propertyList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *propertyList) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid property %q, expected key=value", s)
+	}
+	*l = append(*l, s)
+	return nil
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Sends the list request q builds to a local server and returns its query
+// parameters.
+func listParams(t *testing.T, q eventQuery) url.Values {
+	t.Helper()
+	var params url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params = r.URL.Query()
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+	srv, err := calendar.New(ts.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.BasePath = ts.URL + "/"
+	if _, err := q.call(srv, "primary").Do(); err != nil {
+		t.Fatal(err)
+	}
+	return params
+}
+
+// Returns a query over January 2024 ordered by start time.
+func januaryQuery() eventQuery {
+	return eventQuery{
+		timeMin:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		timeMax:    time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		maxResults: 250,
+		orderBy:    orderStartTime,
+	}
+}
+
+func TestQueryText(t *testing.T) {
+	q := januaryQuery()
+	q.text = "design review"
+	if got := listParams(t, q).Get("q"); got != "design review" {
+		t.Errorf("q = %q, want the search text", got)
+	}
+	if got := listParams(t, januaryQuery()); got["q"] != nil {
+		t.Errorf("sent q = %q without search text", got["q"])
+	}
+}
+
+func TestQueryFlag(t *testing.T) {
+	srv := &fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(1, 10)}}
+	defer useService(srv)()
+	if _, err := runCommand("--query", "standup", "--start", "2024-01-01", "--end", "2024-01-31"); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.query("primary").text; got != "standup" {
+		t.Errorf("listed with text %q, want standup", got)
+	}
+}
+
+func TestQueryUpdatedMin(t *testing.T) {
+	q := januaryQuery()
+	q.updatedMin = time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	if got := listParams(t, q).Get("updatedMin"); got != "2024-01-10T12:00:00Z" {
+		t.Errorf("updatedMin = %q, want 2024-01-10T12:00:00Z", got)
+	}
+	if got := listParams(t, januaryQuery()); got["updatedMin"] != nil {
+		t.Errorf("sent updatedMin = %q without --updated-after", got["updatedMin"])
+	}
+}
+
+func TestUpdatedAfterFlag(t *testing.T) {
+	srv := &fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(1, 10)}}
+	defer useService(srv)()
+	if _, err := runCommand("--updated-after", "2024-01-10T12:00:00Z", "--start", "2024-01-01", "--end", "2024-01-31"); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.query("primary").updatedMin; !got.Equal(time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("listed updated after %v, want 2024-01-10T12:00:00Z", got)
+	}
+	_, err := runCommand("--updated-after", "last tuesday")
+	if err == nil || !strings.Contains(err.Error(), "invalid --updated-after") {
+		t.Errorf("got error %v, want an invalid --updated-after", err)
+	}
+}
+
+func TestPropertyFlag(t *testing.T) {
+	srv := &fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(1, 10)}}
+	defer useService(srv)()
+	if _, err := runCommand("--property", "app=planner", "--property", "kind=task=1", "--start", "2024-01-01", "--end", "2024-01-31"); err != nil {
+		t.Fatal(err)
+	}
+	q := srv.query("primary")
+	want := []string{"app=planner", "kind=task=1"}
+	if got := listParams(t, q)["privateExtendedProperty"]; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("privateExtendedProperty = %q, want %q", got, want)
+	}
+	for _, bad := range []string{"planner", "=planner"} {
+		_, err := runCommand("--property", bad)
+		if err == nil || !strings.Contains(err.Error(), "expected key=value") {
+			t.Errorf("--property %s: got error %v, want expected key=value", bad, err)
+		}
+	}
+}
+
+func TestFetchEventsCollectsPages(t *testing.T) {
+	srv := &fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(7, 3)}}
+	collector := EventCollector{calendar: "primary"}
+	var buf eventBuffer
+	if err := fetchEvents(context.Background(), srv, "primary", januaryQuery(), collector.WriteCallback(context.Background(), &buf)); err != nil {
+		t.Fatal(err)
+	}
+	if collector.pageCounter != 3 || collector.itemCounter != 7 {
+		t.Errorf("collected %d pages and %d events, want 3 and 7", collector.pageCounter, collector.itemCounter)
+	}
+	if len(buf.events) != 7 || buf.events[6].Id != "e7" || buf.events[6].Calendar != "primary" {
+		t.Errorf("wrote %d events, want e1 to e7 of primary", len(buf.events))
+	}
+}
+
+func TestFetchEventsStopsAtLimit(t *testing.T) {
+	srv := &fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(7, 3)}}
+	collector := EventCollector{calendar: "primary", limit: 4}
+	var buf eventBuffer
+	// Reaching the limit stops paging without an error.
+	if err := fetchEvents(context.Background(), srv, "primary", januaryQuery(), collector.WriteCallback(context.Background(), &buf)); err != nil {
+		t.Fatal(err)
+	}
+	if collector.pageCounter != 2 || len(buf.events) != 4 {
+		t.Errorf("collected %d pages and %d events, want 2 and 4", collector.pageCounter, len(buf.events))
+	}
+}
+
+func TestCheckOrderBy(t *testing.T) {
+	for _, c := range []struct {
+		orderBy   string
+		recurring bool
+		ok        bool
+	}{
+		{orderStartTime, false, true},
+		{orderStartTime, true, false},
+		{orderUpdated, true, true},
+		{orderNone, false, true},
+		{"summary", false, false},
+	} {
+		if err := checkOrderBy(c.orderBy, c.recurring); (err == nil) != c.ok {
+			t.Errorf("checkOrderBy(%q, %v) = %v", c.orderBy, c.recurring, err)
+		}
+	}
+}
+
+func TestOrderByStartTimeRequiresExpanding(t *testing.T) {
+	_, err := runCommand("--expand-recurring=false", "--order-by", "startTime", "--start", "2024-01-01", "--end", "2024-01-31")
+	if err == nil || !strings.Contains(err.Error(), "--order-by startTime requires --expand-recurring") {
+		t.Errorf("got error %v, want the incompatible order rejected", err)
+	}
+}
+
+func TestOrderNoneLeavesOutOrderBy(t *testing.T) {
+	q := januaryQuery()
+	q.orderBy = orderNone
+	if got := listParams(t, q); got["orderBy"] != nil {
+		t.Errorf("sent orderBy = %q for unordered fetches", got["orderBy"])
+	}
+	if got := listParams(t, januaryQuery()).Get("orderBy"); got != orderStartTime {
+		t.Errorf("orderBy = %q, want startTime", got)
+	}
+}
+
+func TestEventBufferKeepsPages(t *testing.T) {
+	collector := EventCollector{calendar: "primary"}
+	var buf eventBuffer
+	write := collector.WriteCallback(context.Background(), &buf)
+	for _, page := range eventPages(5, 2) {
+		if err := write(page); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var ids []string
+	for _, item := range buf.events {
+		ids = append(ids, item.Id)
+	}
+	if got := strings.Join(ids, " "); got != "e1 e2 e3 e4 e5" {
+		t.Errorf("buffered %s, want the events of every page in order", got)
+	}
+}
+
+// Returns a fake service with n calendars of events events each, in pages of
+// 3, the IDs of the events prefixed with their calendar.
+func calendarsOf(n, events int) (*fakeService, []string) {
+	srv := &fakeService{pages: map[string][]*calendar.Events{}}
+	var ids []string
+	for c := 0; c < n; c++ {
+		id := fmt.Sprintf("cal%d", c)
+		pages := eventPages(events, 3)
+		for _, page := range pages {
+			for _, item := range page.Items {
+				item.Id = id + "-" + item.Id
+			}
+		}
+		srv.pages[id] = pages
+		ids = append(ids, id)
+	}
+	return srv, ids
+}
+
+func TestFetchMergedConcurrently(t *testing.T) {
+	srv, ids := calendarsOf(6, 10)
+	defer useService(srv)()
+	out, err := runCommand("--calendar", strings.Join(ids, ","), "--concurrency", "3", "--format", "ndjson", "--fields", "id,calendar,summary",
+		"--start", "2024-01-01", "--end", "2024-01-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, line := range lines(out) {
+		var event map[string]string
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("corrupted line %q: %v", line, err)
+		}
+		if !strings.HasPrefix(event["id"], event["calendar"]+"-") {
+			t.Errorf("event %s written with calendar %s", event["id"], event["calendar"])
+		}
+		seen[event["id"]] = true
+	}
+	if len(seen) != 60 {
+		t.Errorf("wrote %d distinct events, want all 60", len(seen))
+	}
+}
+
+func TestFetchMergedFirstErrorStops(t *testing.T) {
+	srv, ids := calendarsOf(3, 10)
+	srv.errs = map[string]error{"cal1": errors.New("backend error")}
+	defer useService(srv)()
+	_, err := runCommand("--calendar", strings.Join(ids, ","), "--concurrency", "1", "--start", "2024-01-01", "--end", "2024-01-31")
+	if err == nil || !strings.Contains(err.Error(), "cal1: backend error") {
+		t.Errorf("got error %v, want the failing calendar's", err)
+	}
+	// With one worker the calendar after the failure is never fetched.
+	if _, ok := srv.queries["cal2"]; ok {
+		t.Error("kept fetching after the first error")
+	}
+}
+
+func TestDedup(t *testing.T) {
+	copyOf := func(id string) *calendar.Event {
+		item := timedEvent(id, "2024-01-15T09:00:00Z", time.Hour)
+		item.ICalUID = "standup@google.com"
+		return item
+	}
+	// A later instance of the same recurring meeting is not a copy.
+	later := copyOf("mine-2")
+	later.Start.DateTime, later.End.DateTime = "2024-01-16T09:00:00Z", "2024-01-16T10:00:00Z"
+	srv := &fakeService{pages: map[string][]*calendar.Events{
+		"mine":  {{Items: []*calendar.Event{copyOf("mine-1"), later}}},
+		"alice": {{Items: []*calendar.Event{copyOf("alice-1"), timedEvent("alice-2", "2024-01-15T11:00:00Z", time.Hour)}}},
+	}}
+	args := []string{"--calendar", "mine,alice", "--dedup"}
+	if got := strings.Join(listedIDs(t, srv, args...), " "); got != "mine-1 alice-2 mine-2" {
+		t.Errorf("listed %s, want the copy on alice's calendar dropped", got)
+	}
+	if got := strings.Join(listedIDs(t, srv, args[:2]...), " "); got != "mine-1 alice-1 alice-2 mine-2" {
+		t.Errorf("listed %s without --dedup, want every copy", got)
+	}
+}
+
+func TestCalendarErrorKeepsCause(t *testing.T) {
+	cause := &googleapi.Error{Code: 404}
+	err := multiError{calendarError{"team@example.com", cause}}
+	if got, want := err.Error(), "team@example.com: "+cause.Error(); got != want {
+		t.Errorf("message %q, want %q", got, want)
+	}
+	if got := err[0].(calendarError).err; got != cause {
+		t.Errorf("cause %v, want %v", got, cause)
+	}
+}
+
+func TestEventIntervalMultiDay(t *testing.T) {
+	trip := allDayEvent("trip", "2024-01-18", 3)
+	start, end, allDay, err := eventInterval(trip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allDay {
+		t.Error("not reported as all-day")
+	}
+	wantStart := time.Date(2024, 1, 18, 0, 0, 0, 0, time.Local)
+	if !start.Equal(wantStart) || !end.Equal(wantStart.AddDate(0, 0, 3)) {
+		t.Errorf("interval %v to %v, want the 3 days from %v", start, end, wantStart)
+	}
+	if d, ok := eventDuration(&Event{Event: trip}); !ok || d != 72*time.Hour {
+		t.Errorf("duration %v, %v, want 72h", d, ok)
+	}
+	out := format(t, "csv", formatOptions{fields: mustParseFields(t, "start,end"), noHeader: true}, []*Event{{Event: trip}})
+	if want := "2024-01-18,2024-01-20\n"; string(out) != want {
+		t.Errorf("wrote %q, want the inclusive last day %q", out, want)
+	}
+}
+
+func TestEventIntervalNoEnd(t *testing.T) {
+	item := timedEvent("x", "2024-01-15T10:00:00Z", time.Hour)
+	item.End = nil
+	start, _, _, err := eventInterval(item)
+	if err != errNoEnd {
+		t.Errorf("got error %v, want errNoEnd", err)
+	}
+	if start.IsZero() {
+		t.Error("start not returned without an end")
+	}
+}
+
+func TestSharedPropertyFlag(t *testing.T) {
+	srv := &fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(1, 10)}}
+	defer useService(srv)()
+	if _, err := runCommand("--shared-property", "room=4B", "--shared-property", "team=ops", "--property", "app=planner", "--start", "2024-01-01", "--end", "2024-01-31"); err != nil {
+		t.Fatal(err)
+	}
+	params := listParams(t, srv.query("primary"))
+	want := []string{"room=4B", "team=ops"}
+	if got := params["sharedExtendedProperty"]; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("sharedExtendedProperty = %q, want %q", got, want)
+	}
+	if got := params["privateExtendedProperty"]; strings.Join(got, " ") != "app=planner" {
+		t.Errorf("privateExtendedProperty = %q, want only app=planner", got)
+	}
+	if _, err := runCommand("--shared-property", "room"); err == nil || !strings.Contains(err.Error(), "expected key=value") {
+		t.Errorf("--shared-property room: got error %v, want expected key=value", err)
+	}
+}
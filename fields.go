@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// A field is a named output column extracted from an event.
+type field struct {
+	name string
+	// description says what the field holds, for the fields command.
+	description string
+	value       func(item *Event, o *fieldOptions) string
+}
+
+// fieldOptions adjust how field values are extracted.
+type fieldOptions struct {
+	// onlyEmail leaves display names out of attendee lists.
+	onlyEmail bool
+	// colors describes event color IDs for the color field.
+	colors map[string]string
+	// stripHTML converts descriptions from HTML to plain text.
+	stripHTML bool
+	// dateLayout formats the start and end fields, or is empty to write
+	// them as the API gives them.
+	dateLayout string
+}
+
+// Returns o with the start and end fields as the API gives them, for formats
+// read by programs.
+func (o fieldOptions) rfc3339() fieldOptions {
+	o.dateLayout = ""
+	return o
+}
+
+// Formats s, a date-time or the date of an all-day event as the API gives
+// them, with the dateLayout of o.
+func (o *fieldOptions) formatTime(s string) string {
+	if o.dateLayout == "" {
+		return s
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.Format(o.dateLayout)
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.Format(dateOnlyLayout(o.dateLayout))
+	}
+	return s
+}
+
+// Fields written when --fields is not given.
+const defaultFields = "start,end,summary,location,status,id"
+
+// The --fields value selecting every field.
+const allFields = "all"
+
+// Every field, in the order --fields all writes them.
+var fieldList = []field{
+	{"start", "Start time, or date of all-day events", func(item *Event, o *fieldOptions) string { return o.formatTime(eventTime(item.Start)) }},
+	{"end", "End time, or last day of all-day events", func(item *Event, o *fieldOptions) string { return o.formatTime(endTime(item)) }},
+	{"weekday", "Day of the week the event starts on, like Monday", func(item *Event, o *fieldOptions) string {
+		if start := eventStart(item); !start.IsZero() {
+			return start.Weekday().String()
+		}
+		return ""
+	}},
+	{"isoweek", "ISO 8601 week the event starts in, like 2024-W03", func(item *Event, o *fieldOptions) string {
+		if start := eventStart(item); !start.IsZero() {
+			return isoWeek(start)
+		}
+		return ""
+	}},
+	{"summary", "Title", func(item *Event, o *fieldOptions) string { return item.Summary }},
+	{"location", "Location", func(item *Event, o *fieldOptions) string { return item.Location }},
+	{"description", "Description, as HTML unless --strip-html is given", func(item *Event, o *fieldOptions) string {
+		if o.stripHTML {
+			return htmlToText(item.Description)
+		}
+		return item.Description
+	}},
+	{"status", "confirmed, tentative or cancelled", func(item *Event, o *fieldOptions) string { return item.Status }},
+	{"transparency", "transparent for events shown as free, opaque or empty for busy ones", func(item *Event, o *fieldOptions) string { return item.Transparency }},
+	{"visibility", "public, private or confidential, or empty for the calendar's default", func(item *Event, o *fieldOptions) string { return item.Visibility }},
+	{"attendees", "Attendees and their responses, separated by semicolons", attendees},
+	{"organizer", "Email address of the organizer", func(item *Event, o *fieldOptions) string { return organizerEmail(item) }},
+	{"creator", "Email address of whoever created the event", func(item *Event, o *fieldOptions) string { return creatorEmail(item) }},
+	{"id", "Event ID, as taken by delete, the same in every listing", func(item *Event, o *fieldOptions) string { return item.Id }},
+	{"recurringEventId", "ID of the recurring event an instance belongs to", func(item *Event, o *fieldOptions) string { return item.RecurringEventId }},
+	{"originalStart", "Start an instance of a recurring event was scheduled for by the recurrence", func(item *Event, o *fieldOptions) string {
+		return o.formatTime(eventTime(item.OriginalStartTime))
+	}},
+	{"htmlLink", "Link to the event in Google Calendar", func(item *Event, o *fieldOptions) string { return item.HtmlLink }},
+	{"calendar", "ID of the calendar listing the event", func(item *Event, o *fieldOptions) string { return item.Calendar }},
+	{"recurrence", "RRULE, EXRULE, RDATE and EXDATE lines of recurring events", func(item *Event, o *fieldOptions) string { return strings.Join(item.Recurrence, " ") }},
+	{"meetLink", "Video conference link", func(item *Event, o *fieldOptions) string { return meetLink(item) }},
+	{"properties", "Private extended properties as a JSON object", func(item *Event, o *fieldOptions) string {
+		return propertiesText(privateProperties(item))
+	}},
+	{"sharedProperties", "Shared extended properties as a JSON object", func(item *Event, o *fieldOptions) string {
+		return propertiesText(sharedProperties(item))
+	}},
+	{"color", "Color name and hex value", eventColor},
+	{"attachments", "Attached files as title (fileUrl), separated by semicolons", attachments},
+}
+
+// Values JSON formats write for fields with more structure than their text,
+// by field name.
+var fieldJSONValues = map[string]func(item *Event, o *fieldOptions) interface{}{
+	"attachments": attachmentList,
+	"properties": func(item *Event, o *fieldOptions) interface{} {
+		return propertiesValue(privateProperties(item))
+	},
+	"sharedProperties": func(item *Event, o *fieldOptions) interface{} {
+		return propertiesValue(sharedProperties(item))
+	},
+}
+
+// Returns the end of item as written in the end field. All-day events show
+// their last day rather than the exclusive end date the API gives.
+func endTime(item *Event) string {
+	start, end, allDay, err := eventInterval(item.Event)
+	if !allDay || err != nil || !end.After(start) {
+		return eventTime(item.End)
+	}
+	return end.AddDate(0, 0, -1).Format("2006-01-02")
+}
+
+// Returns the video conference URL of item, preferring the Hangouts/Meet link
+// over the conference data entry points, or "" when it has none.
+func meetLink(item *Event) string {
+	if item.HangoutLink != "" {
+		return item.HangoutLink
+	}
+	if item.ConferenceData == nil {
+		return ""
+	}
+	for _, e := range item.ConferenceData.EntryPoints {
+		if e.EntryPointType == "video" && e.Uri != "" {
+			return e.Uri
+		}
+	}
+	return ""
+}
+
+// Returns the private extended properties of item, or nil when it has none.
+func privateProperties(item *Event) map[string]string {
+	if item.ExtendedProperties == nil {
+		return nil
+	}
+	return item.ExtendedProperties.Private
+}
+
+// Returns the shared extended properties of item, or nil when it has none.
+func sharedProperties(item *Event) map[string]string {
+	if item.ExtendedProperties == nil {
+		return nil
+	}
+	return item.ExtendedProperties.Shared
+}
+
+// Returns props as a JSON object, or "" when there are none.
+func propertiesText(props map[string]string) string {
+	if len(props) == 0 {
+		return ""
+	}
+	b, err := json.Marshal(props)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// Returns props for JSON formats to write as an object, or nil when there
+// are none.
+func propertiesValue(props map[string]string) interface{} {
+	if len(props) == 0 {
+		return nil
+	}
+	return props
+}
+
+var (
+	htmlLineBreak = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6])\s*>`)
+	htmlTag       = regexp.MustCompile(`<[^>]*>`)
+	blankLines    = regexp.MustCompile(`\n[ \t]*\n(\s*\n)+`)
+)
+
+// Converts s, the HTML of a description from a rich text editor, to plain
+// text: line breaks and the ends of paragraphs, list items and the like
+// become newlines, other tags are dropped and entities unescaped.
+func htmlToText(s string) string {
+	s = htmlLineBreak.ReplaceAllString(s, "\n")
+	s = htmlTag.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	s = blankLines.ReplaceAllString(s, "\n\n")
+	return strings.TrimSpace(s)
+}
+
+// eventAttachment is an attachment as written by JSON formats.
+type eventAttachment struct {
+	Title   string `json:"title"`
+	FileURL string `json:"fileUrl"`
+}
+
+// Returns the attachments of item as a list for JSON formats to write.
+func attachmentList(item *Event, o *fieldOptions) interface{} {
+	list := make([]eventAttachment, len(item.Attachments))
+	for i, a := range item.Attachments {
+		list[i] = eventAttachment{Title: a.Title, FileURL: a.FileUrl}
+	}
+	return list
+}
+
+// Returns the attachments of item joined with semicolons, each as
+// "title (fileUrl)".
+func attachments(item *Event, o *fieldOptions) string {
+	list := make([]string, len(item.Attachments))
+	for i, a := range item.Attachments {
+		list[i] = a.Title + " (" + a.FileUrl + ")"
+	}
+	return strings.Join(list, ";")
+}
+
+// Returns the attendees of item joined with semicolons, each as
+// "Name <email> (responseStatus)", leaving out the name when it is unknown
+// or onlyEmail is set.
+func attendees(item *Event, o *fieldOptions) string {
+	list := make([]string, len(item.Attendees))
+	for i, a := range item.Attendees {
+		s := a.Email
+		if a.DisplayName != "" && !o.onlyEmail {
+			s = a.DisplayName + " <" + a.Email + ">"
+		}
+		if a.ResponseStatus != "" {
+			s += " (" + a.ResponseStatus + ")"
+		}
+		list[i] = s
+	}
+	return strings.Join(list, ";")
+}
+
+// Parses a comma-separated list of field names, or "all" for every field.
+func parseFields(s string) ([]field, error) {
+	if strings.TrimSpace(s) == allFields {
+		return append([]field(nil), fieldList...), nil
+	}
+	var fields []field
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		f, ok := lookupField(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q, valid fields are: %s",
+				name, strings.Join(fieldNames(fieldList), ", "))
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// Reports whether fields include the field called name.
+func hasField(fields []field, name string) bool {
+	for _, f := range fields {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the field called name, and whether there is one.
+func lookupField(name string) (field, bool) {
+	for _, f := range fieldList {
+		if f.name == name {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// Returns the names of fields, for use as a header row.
+func fieldNames(fields []field) []string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.name
+	}
+	return names
+}
+
+// Extracts the value of each field from item.
+func fieldValues(fields []field, item *Event, o *fieldOptions) []string {
+	row := make([]string, len(fields))
+	for i, f := range fields {
+		row[i] = f.value(item, o)
+	}
+	return row
+}
+
+// Prints the name and description of every field.
+func listFields(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("calendar fields", flag.ContinueOnError)
+	if err := parseFlags(fs, args, stderr); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	for _, f := range fieldList {
+		fmt.Fprintf(w, "%s\t%s\n", f.name, f.description)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("unable to write fields: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// Returns an event organized by ann with three attendees.
+func meetingWithAttendees() *Event {
+	item := timedEvent("a1", "2024-01-15T10:00:00Z", time.Hour)
+	item.Organizer = &calendar.EventOrganizer{Email: "ann@example.com"}
+	item.Attendees = []*calendar.EventAttendee{
+		{Email: "ann@example.com", DisplayName: "Ann Lee", ResponseStatus: "accepted"},
+		{Email: "bob@example.com", ResponseStatus: "declined"},
+		{Email: "cy@example.com", DisplayName: "Cy", ResponseStatus: "needsAction"},
+	}
+	return &Event{Event: item, Calendar: "primary"}
+}
+
+func TestAttendeeFieldsCSV(t *testing.T) {
+	fields := mustParseFields(t, "organizer,attendees")
+	out := format(t, "csv", formatOptions{fields: fields, noHeader: true}, []*Event{meetingWithAttendees()})
+	want := "ann@example.com,Ann Lee <ann@example.com> (accepted);bob@example.com (declined);Cy <cy@example.com> (needsAction)\n"
+	if string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	out = format(t, "csv", formatOptions{fields: fields, noHeader: true, fieldOptions: fieldOptions{onlyEmail: true}}, []*Event{meetingWithAttendees()})
+	want = "ann@example.com,ann@example.com (accepted);bob@example.com (declined);cy@example.com (needsAction)\n"
+	if string(out) != want {
+		t.Errorf("with --only-email got %q, want %q", out, want)
+	}
+}
+
+func TestAttendeeFieldsJSON(t *testing.T) {
+	out := format(t, "json", formatOptions{fields: mustParseFields(t, "organizer,attendees")}, []*Event{meetingWithAttendees()})
+	var got []map[string]string
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("invalid JSON %s: %v", out, err)
+	}
+	if len(got) != 1 || got[0]["organizer"] != "ann@example.com" {
+		t.Fatalf("got %v, want one event organized by ann", got)
+	}
+	if n := strings.Count(got[0]["attendees"], ";") + 1; n != 3 {
+		t.Errorf("attendees %q, want 3", got[0]["attendees"])
+	}
+}
+
+func TestPropertiesField(t *testing.T) {
+	tagged := timedEvent("t1", "2024-01-15T10:00:00Z", time.Hour)
+	tagged.ExtendedProperties = &calendar.EventExtendedProperties{Private: map[string]string{"app": "planner", "kind": "task"}}
+	plain := timedEvent("p1", "2024-01-15T12:00:00Z", time.Hour)
+	out := format(t, "csv", formatOptions{fields: mustParseFields(t, "id,properties"), noHeader: true}, []*Event{{Event: tagged}, {Event: plain}})
+	want := "t1,\"{\"\"app\"\":\"\"planner\"\",\"\"kind\"\":\"\"task\"\"}\"\np1,\n"
+	if string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	// JSON formats write the properties as an object, not a string of one.
+	out = format(t, "ndjson", formatOptions{fields: mustParseFields(t, "id,properties")}, []*Event{{Event: tagged}, {Event: plain}})
+	want = `{"id":"t1","properties":{"app":"planner","kind":"task"}}` + "\n" + `{"id":"p1","properties":null}` + "\n"
+	if string(out) != want {
+		t.Errorf("ndjson got %q, want %q", out, want)
+	}
+}
+
+func TestAllFields(t *testing.T) {
+	out := format(t, "json", formatOptions{fields: mustParseFields(t, "all")}, fixtureEvents()[:1])
+	var got []map[string]json.RawMessage
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d rows, want 1", len(got))
+	}
+	if len(got[0]) != len(fieldList) {
+		t.Errorf("got %d columns, want %d", len(got[0]), len(fieldList))
+	}
+	for _, f := range fieldList {
+		if _, ok := got[0][f.name]; !ok {
+			t.Errorf("row has no %s column", f.name)
+		}
+	}
+}
+
+func TestFieldsCommand(t *testing.T) {
+	out, err := runCommand("fields")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != len(fieldList) {
+		t.Fatalf("listed %d fields, want %d:\n%s", len(lines), len(fieldList), out)
+	}
+	for i, f := range fieldList {
+		if name := strings.Fields(lines[i])[0]; name != f.name {
+			t.Errorf("line %d names %q, want %q", i+1, name, f.name)
+		}
+		if f.description == "" || !strings.HasSuffix(lines[i], f.description) {
+			t.Errorf("line %q lacks the description of %s", lines[i], f.name)
+		}
+	}
+}
+
+func TestDateFormat(t *testing.T) {
+	fields := mustParseFields(t, "start,end,summary")
+	for _, c := range []struct {
+		format string
+		want   string
+	}{
+		{"Mon Jan 2 3:04pm", "Mon Mar 4 9:30am,Mon Mar 4 11:00am,\"Planning, Q2; budget\"\nFri Mar 8,Fri Mar 8,Offsite\n"},
+		{"02/01/2006 15:04", "04/03/2024 09:30,04/03/2024 11:00,\"Planning, Q2; budget\"\n08/03/2024,08/03/2024,Offsite\n"},
+		{"kitchen", "9:30AM,11:00AM,\"Planning, Q2; budget\"\n2024-03-08,2024-03-08,Offsite\n"},
+	} {
+		layout, err := parseDateLayout(c.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := formatOptions{fields: fields, noHeader: true, fieldOptions: fieldOptions{dateLayout: layout}}
+		if got := string(format(t, "csv", opts, fixtureEvents())); got != c.want {
+			t.Errorf("--date-format %q got %q, want %q", c.format, got, c.want)
+		}
+		// JSON stays on RFC3339 for programs.
+		got := string(format(t, "ndjson", opts, fixtureEvents()[:1]))
+		if !strings.Contains(got, `"start":"2024-03-04T09:30:00Z"`) {
+			t.Errorf("--date-format %q changed ndjson to %q", c.format, got)
+		}
+	}
+	if _, err := parseDateLayout("soon"); err == nil {
+		t.Error("accepted a layout without any element of the reference time")
+	}
+}
+
+func TestDescriptionHTML(t *testing.T) {
+	item := timedEvent("d1", "2024-01-15T10:00:00Z", time.Hour)
+	item.Description = `<p>Agenda:</p><ul><li><b>Budget</b> &amp; hiring</li><li>Q&amp;A</li></ul>Notes<br>at &lt;wiki&gt;`
+	events := []*Event{{Event: item}}
+	fields := mustParseFields(t, "id,description")
+	for _, c := range []struct {
+		name string
+		opts formatOptions
+		want string
+	}{
+		{"raw", formatOptions{}, "d1,<p>Agenda:</p><ul><li><b>Budget</b> &amp; hiring</li><li>Q&amp;A</li></ul>Notes<br>at &lt;wiki&gt;\n"},
+		{"strip", formatOptions{fieldOptions: fieldOptions{stripHTML: true}}, "d1,Agenda: Budget & hiring Q&A Notes at <wiki>\n"},
+		{"strip and keep newlines", formatOptions{keepNewlines: true, fieldOptions: fieldOptions{stripHTML: true}}, "d1,\"Agenda:\nBudget & hiring\nQ&A\nNotes\nat <wiki>\"\n"},
+	} {
+		c.opts.fields, c.opts.noHeader = fields, true
+		if got := string(format(t, "csv", c.opts, events)); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestAttachmentsField(t *testing.T) {
+	item := timedEvent("a1", "2024-01-15T10:00:00Z", time.Hour)
+	item.Attachments = []*calendar.EventAttachment{
+		{Title: "Agenda", FileUrl: "https://drive.google.com/open?id=1"},
+		{Title: "Budget; draft", FileUrl: "https://drive.google.com/open?id=2"},
+	}
+	plain := timedEvent("p1", "2024-01-15T12:00:00Z", time.Hour)
+	events := []*Event{{Event: item}, {Event: plain}}
+	fields := mustParseFields(t, "id,attachments")
+	out := format(t, "csv", formatOptions{fields: fields, noHeader: true}, events)
+	want := "a1,Agenda (https://drive.google.com/open?id=1);Budget; draft (https://drive.google.com/open?id=2)\np1,\n"
+	if string(out) != want {
+		t.Errorf("csv got %q, want %q", out, want)
+	}
+	out = format(t, "json", formatOptions{fields: fields}, events)
+	var got []struct {
+		ID          string `json:"id"`
+		Attachments []struct {
+			Title   string `json:"title"`
+			FileURL string `json:"fileUrl"`
+		} `json:"attachments"`
+	}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("%v in %s", err, out)
+	}
+	if len(got) != 2 || len(got[0].Attachments) != 2 || got[1].Attachments == nil || len(got[1].Attachments) != 0 {
+		t.Fatalf("got %s, want two attachments on a1 and an empty list on p1", out)
+	}
+	if a := got[0].Attachments[1]; a.Title != "Budget; draft" || a.FileURL != "https://drive.google.com/open?id=2" {
+		t.Errorf("second attachment is %+v", a)
+	}
+}
+
+func TestVisibilityField(t *testing.T) {
+	private := timedEvent("p1", "2024-01-15T10:00:00Z", time.Hour)
+	private.Visibility = "private"
+	plain := timedEvent("d1", "2024-01-15T12:00:00Z", time.Hour)
+	out := format(t, "csv", formatOptions{fields: mustParseFields(t, "id,visibility"), noHeader: true}, []*Event{{Event: private}, {Event: plain}})
+	if want := "p1,private\nd1,\n"; string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestSharedPropertiesField(t *testing.T) {
+	item := timedEvent("t1", "2024-01-15T10:00:00Z", time.Hour)
+	item.ExtendedProperties = &calendar.EventExtendedProperties{
+		Private: map[string]string{"app": "planner"},
+		Shared:  map[string]string{"room": "4B"},
+	}
+	out := format(t, "csv", formatOptions{fields: mustParseFields(t, "id,properties,sharedProperties"), noHeader: true}, []*Event{{Event: item}})
+	// Each column holds only its own kind of properties.
+	if want := "t1,\"{\"\"app\"\":\"\"planner\"\"}\",\"{\"\"room\"\":\"\"4B\"\"}\"\n"; string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	shared := timedEvent("s1", "2024-01-15T12:00:00Z", time.Hour)
+	shared.ExtendedProperties = &calendar.EventExtendedProperties{Shared: map[string]string{"room": "4B"}}
+	out = format(t, "csv", formatOptions{fields: mustParseFields(t, "id,properties"), noHeader: true}, []*Event{{Event: shared}})
+	if want := "s1,\n"; string(out) != want {
+		t.Errorf("got %q, want no private properties", out)
+	}
+	out = format(t, "ndjson", formatOptions{fields: mustParseFields(t, "id,properties,sharedProperties")}, []*Event{{Event: item}, {Event: shared}})
+	want := `{"id":"t1","properties":{"app":"planner"},"sharedProperties":{"room":"4B"}}` + "\n" +
+		`{"id":"s1","properties":null,"sharedProperties":{"room":"4B"}}` + "\n"
+	if string(out) != want {
+		t.Errorf("ndjson got %q, want %q", out, want)
+	}
+}
+
+func TestWeekdayAndISOWeekFields(t *testing.T) {
+	srv := &fakeService{pages: map[string][]*calendar.Events{"primary": {{Items: []*calendar.Event{
+		// Friday 1 January 2021 in Tokyo, in the last week of 2020.
+		timedEvent("nye", "2020-12-31T23:30:00Z", time.Hour),
+		// Monday 30 December 2024, in the first week of 2025.
+		allDayEvent("holiday", "2024-12-30", 1),
+		// Sunday 3 January 2021, still in the last week of 2020.
+		timedEvent("brunch", "2021-01-03T02:00:00Z", time.Hour),
+	}}}}}
+	defer useService(srv)()
+	out, err := runCommand("--start", "2020-12-01", "--end", "2025-01-31", "--timezone", "Asia/Tokyo", "--fields", "id,weekday,isoweek", "--no-header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "nye,Friday,2020-W53\nholiday,Monday,2025-W01\nbrunch,Sunday,2020-W53\n"
+	if out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestRecurringInstanceIDs(t *testing.T) {
+	var items []*calendar.Event
+	for _, day := range []string{"15", "22"} {
+		item := timedEvent("standup_202401"+day+"T090000Z", "2024-01-"+day+"T09:00:00Z", 15*time.Minute)
+		item.RecurringEventId = "standup"
+		item.OriginalStartTime = &calendar.EventDateTime{DateTime: "2024-01-" + day + "T09:00:00Z"}
+		items = append(items, item)
+	}
+	// The second instance was moved, keeping the start it was scheduled for.
+	items[1].Start.DateTime, items[1].End.DateTime = "2024-01-23T10:00:00Z", "2024-01-23T10:15:00Z"
+	items = append(items, timedEvent("review", "2024-01-24T09:00:00Z", time.Hour))
+	got := listedIDs(t, serviceWith(items...), "--fields", "id,recurringEventId,originalStart", "--timezone", "UTC")
+	want := []string{
+		"standup_20240115T090000Z,standup,2024-01-15T09:00:00Z",
+		"standup_20240122T090000Z,standup,2024-01-22T09:00:00Z",
+		"review,,",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("wrote\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDefaultFieldsIncludeID(t *testing.T) {
+	defer useService(serviceWith(timedEvent("e1", "2024-01-15T09:00:00Z", time.Hour)))()
+	out, err := runCommand("--start", "2024-01-01", "--end", "2024-01-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := lines(out)
+	if len(got) != 2 || !strings.HasSuffix(got[0], ",id") || !strings.HasSuffix(got[1], ",e1") {
+		t.Errorf("wrote %q, want the id column in the default output", got)
+	}
+}
+
+// Returns an event with the Meet link hangout, when set, and conference
+// entry points of each type=uri pair in entries.
+func conferenceEvent(id, hangout string, entries ...string) *calendar.Event {
+	item := timedEvent(id, "2024-01-15T10:00:00Z", time.Hour)
+	item.HangoutLink = hangout
+	if len(entries) > 0 {
+		item.ConferenceData = &calendar.ConferenceData{}
+		for _, e := range entries {
+			kv := strings.SplitN(e, "=", 2)
+			item.ConferenceData.EntryPoints = append(item.ConferenceData.EntryPoints, &calendar.EntryPoint{EntryPointType: kv[0], Uri: kv[1]})
+		}
+	}
+	return item
+}
+
+func TestMeetLinkField(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		item *calendar.Event
+		want string
+	}{
+		{"hangout link", conferenceEvent("c1", "https://meet.google.com/abc-defg-hij", "video=https://zoom.us/j/1"), "https://meet.google.com/abc-defg-hij"},
+		{"first video entry point", conferenceEvent("c2", "", "phone=tel:+1-555-0100", "video=https://zoom.us/j/1", "video=https://zoom.us/j/2"), "https://zoom.us/j/1"},
+		{"no video entry point", conferenceEvent("c3", "", "phone=tel:+1-555-0100"), ""},
+		{"no conference data", conferenceEvent("c4", ""), ""},
+	} {
+		out := format(t, "csv", formatOptions{fields: mustParseFields(t, "meetLink"), noHeader: true}, []*Event{{Event: c.item}})
+		if got := strings.TrimSuffix(string(out), "\n"); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
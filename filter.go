@@ -0,0 +1,149 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// An eventFilter reports whether an event should be kept.
+type eventFilter func(item *Event) bool
+
+// Reports whether item is an all-day event, which has a start date but no
+// start time.
+func isAllDay(item *Event) bool {
+	return item.Start != nil && item.Start.DateTime == "" && item.Start.Date != ""
+}
+
+// Reports whether item is shown as free, so that it takes no busy time. Events
+// without a transparency are busy.
+func isFree(item *Event) bool {
+	return item.Transparency == "transparent"
+}
+
+// A filter dropping events shown as free.
+func excludeFree(item *Event) bool {
+	return !isFree(item)
+}
+
+// Returns a filter keeping only all-day events when allDay, or only timed
+// events otherwise.
+func allDayOnly(allDay bool) eventFilter {
+	return func(item *Event) bool {
+		return isAllDay(item) == allDay
+	}
+}
+
+// Returns a filter dropping events without a start time, like some cancelled
+// events, with a warning for each.
+func skipNoStart() eventFilter {
+	return func(item *Event) bool {
+		if _, err := parseEventTime(item.Start); err == nil {
+			return true
+		}
+		infof("Warning: skipping event %s without a valid start time", item.Id)
+		return false
+	}
+}
+
+// Event statuses accepted by --status.
+var eventStatuses = map[string]bool{"confirmed": true, "tentative": true, "cancelled": true}
+
+// Returns a filter keeping events with one of statuses.
+func statusIn(statuses []string) eventFilter {
+	return func(item *Event) bool {
+		for _, s := range statuses {
+			if item.Status == s {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Event visibilities accepted by --visibility.
+var eventVisibilities = map[string]bool{"default": true, "public": true, "private": true, "confidential": true}
+
+// Returns a filter keeping events with one of visibilities. Events without a
+// visibility have the default one.
+func visibilityIn(visibilities []string) eventFilter {
+	return func(item *Event) bool {
+		v := item.Visibility
+		if v == "" {
+			v = "default"
+		}
+		for _, want := range visibilities {
+			if v == want {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Returns a filter keeping events whose person, like the creator, has one of
+// emails, ignoring case.
+func personIn(person func(item *Event) string, emails []string) eventFilter {
+	return func(item *Event) bool {
+		email := person(item)
+		for _, want := range emails {
+			if strings.EqualFold(email, want) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Returns the email address of the creator of item, or "" when unknown.
+func creatorEmail(item *Event) string {
+	if item.Creator == nil {
+		return ""
+	}
+	return item.Creator.Email
+}
+
+// Returns the email address of the organizer of item, or "" when unknown.
+func organizerEmail(item *Event) string {
+	if item.Organizer == nil {
+		return ""
+	}
+	return item.Organizer.Email
+}
+
+// Returns a filter dropping events that email, or the attendee marked as the
+// authorized user, has declined.
+func excludeDeclined(email string) eventFilter {
+	return func(item *Event) bool {
+		for _, a := range item.Attendees {
+			if (a.Self || strings.EqualFold(a.Email, email)) && a.ResponseStatus == "declined" {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Returns the length of item and whether it has both a start and an end.
+// All-day events last 24 hours per day, even across daylight saving changes.
+func eventDuration(item *Event) (time.Duration, bool) {
+	start, end, allDay, err := eventInterval(item.Event)
+	if err != nil {
+		return 0, false
+	}
+	if allDay {
+		return end.Sub(start).Round(24 * time.Hour), true
+	}
+	return end.Sub(start), true
+}
+
+// Returns a filter keeping events lasting at least min and at most max, where
+// a zero bound is not checked. Events without an end are kept when keepNoEnd.
+func durationBetween(min, max time.Duration, keepNoEnd bool) eventFilter {
+	return func(item *Event) bool {
+		d, ok := eventDuration(item)
+		if !ok {
+			return keepNoEnd
+		}
+		return (min == 0 || d >= min) && (max == 0 || d <= max)
+	}
+}
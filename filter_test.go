@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// Writes the events collected from pages with the command line args and
+// returns the IDs of the events written, read back from the summaries
+// timedEvent gives them.
+func listedIDs(t *testing.T, srv *fakeService, args ...string) []string {
+	t.Helper()
+	defer useService(srv)()
+	args = append([]string{"--start", "2024-01-01", "--end", "2024-01-31", "--fields", "summary", "--no-header"}, args...)
+	out, err := runCommand(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := lines(out)
+	for i, summary := range ids {
+		ids[i] = strings.TrimPrefix(summary, "Event ")
+	}
+	return ids
+}
+
+// Returns a service serving items as the only page of the primary calendar.
+func serviceWith(items ...*calendar.Event) *fakeService {
+	return &fakeService{pages: map[string][]*calendar.Events{"primary": {{Items: items}}}}
+}
+
+func TestExcludeDeclined(t *testing.T) {
+	attending := func(id, status string) *calendar.Event {
+		item := timedEvent(id, "2024-01-15T10:00:00Z", time.Hour)
+		item.Attendees = []*calendar.EventAttendee{
+			{Email: "other@example.com", ResponseStatus: "declined"},
+			{Email: "me@example.com", ResponseStatus: status},
+		}
+		return item
+	}
+	srv := serviceWith(attending("going", "accepted"), attending("skipping", "declined"), attending("maybe", "tentative"))
+	srv.calendars = []*calendar.CalendarListEntry{{Id: "me@example.com", Primary: true}}
+	got := listedIDs(t, srv, "--exclude-declined")
+	if len(got) != 2 || got[0] != "going" || got[1] != "maybe" {
+		t.Errorf("listed %q, want going and maybe", got)
+	}
+}
+
+func TestExcludeDeclinedBySelf(t *testing.T) {
+	item := timedEvent("skipping", "2024-01-15T10:00:00Z", time.Hour)
+	item.Attendees = []*calendar.EventAttendee{{Email: "alias@example.com", Self: true, ResponseStatus: "declined"}}
+	if excludeDeclined("me@example.com")(&Event{Event: item}) {
+		t.Error("kept an event declined by the attendee marked self")
+	}
+}
+
+func TestDurationBetweenBoundaries(t *testing.T) {
+	lasting := func(d time.Duration) *Event {
+		return &Event{Event: timedEvent("x", "2024-01-15T10:00:00Z", d)}
+	}
+	noEnd := &Event{Event: &calendar.Event{Start: &calendar.EventDateTime{DateTime: "2024-01-15T10:00:00Z"}}}
+	for _, c := range []struct {
+		name      string
+		min, max  time.Duration
+		keepNoEnd bool
+		item      *Event
+		want      bool
+	}{
+		{"exactly min", 30 * time.Minute, time.Hour, false, lasting(30 * time.Minute), true},
+		{"exactly max", 30 * time.Minute, time.Hour, false, lasting(time.Hour), true},
+		{"below min", 30 * time.Minute, time.Hour, false, lasting(29 * time.Minute), false},
+		{"above max", 30 * time.Minute, time.Hour, false, lasting(61 * time.Minute), false},
+		{"no max", 30 * time.Minute, 0, false, lasting(10 * time.Hour), true},
+		{"no min", 0, time.Hour, false, lasting(time.Minute), true},
+		{"all day is 24h", 24 * time.Hour, 24 * time.Hour, false, &Event{Event: allDayEvent("x", "2024-01-15", 1)}, true},
+		{"no end", 0, time.Hour, false, noEnd, false},
+		{"no end kept", 0, time.Hour, true, noEnd, true},
+	} {
+		if got := durationBetween(c.min, c.max, c.keepNoEnd)(c.item); got != c.want {
+			t.Errorf("%s: kept %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDurationFlagsValidated(t *testing.T) {
+	for _, args := range [][]string{
+		{"--min-duration", "-1h"},
+		{"--min-duration", "2h", "--max-duration", "1h"},
+	} {
+		args = append(args, "--start", "2024-01-01", "--end", "2024-01-31")
+		if _, err := runCommand(args...); err == nil {
+			t.Errorf("%v succeeded", args)
+		}
+	}
+}
+
+func TestAllDayAndTimedOnly(t *testing.T) {
+	mixed := func() *fakeService {
+		return serviceWith(
+			timedEvent("standup", "2024-01-15T09:00:00Z", 15*time.Minute),
+			allDayEvent("holiday", "2024-01-16", 1),
+			timedEvent("review", "2024-01-16T14:00:00Z", time.Hour),
+			allDayEvent("trip", "2024-01-18", 3),
+		)
+	}
+	for _, c := range []struct {
+		flag string
+		want string
+	}{
+		{"--all-day-only", "holiday trip"},
+		{"--timed-only", "standup review"},
+	} {
+		if got := strings.Join(listedIDs(t, mixed(), c.flag), " "); got != c.want {
+			t.Errorf("%s listed %s, want %s", c.flag, got, c.want)
+		}
+	}
+	if _, err := runCommand("--all-day-only", "--timed-only", "--start", "2024-01-01", "--end", "2024-01-31"); err == nil {
+		t.Error("--all-day-only with --timed-only succeeded")
+	}
+}
+
+func TestNilStart(t *testing.T) {
+	withCancelled := func() *fakeService {
+		return serviceWith(
+			timedEvent("standup", "2024-01-15T09:00:00Z", 15*time.Minute),
+			&calendar.Event{Id: "gone", Status: "cancelled"},
+		)
+	}
+	defer useService(withCancelled())()
+	for _, format := range []string{"csv", "json", "ndjson", "ics", "markdown", "html", "pretty", "template"} {
+		args := []string{"--start", "2024-01-01", "--end", "2024-01-31", "--format", format}
+		if format == "template" {
+			args = append(args, "--template", "{{.Start}} {{duration .Start .End}}")
+		}
+		if _, err := runCommand(args...); err != nil {
+			t.Errorf("--format %s: %v", format, err)
+		}
+	}
+	out, err := runCommand("--start", "2024-01-01", "--end", "2024-01-31", "--fields", "start,end,id", "--no-header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out, "\n,,gone\n") {
+		t.Errorf("got %q, want the event written with a blank start and end", out)
+	}
+	out, err = runCommand("--start", "2024-01-01", "--end", "2024-01-07", "--group-by", "day", "--no-header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "0001") {
+		t.Errorf("--group-by day put the event without a start in a day:\n%s", out)
+	}
+
+	var stdout, stderr strings.Builder
+	args := []string{"--start", "2024-01-01", "--end", "2024-01-31", "--fields", "id", "--no-header", "--skip-no-start"}
+	if err := run(context.Background(), args, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if got := stdout.String(); got != "standup\n" {
+		t.Errorf("--skip-no-start listed %q, want only standup", got)
+	}
+	if !strings.Contains(stderr.String(), "skipping event gone") {
+		t.Errorf("--skip-no-start warned %q, want the skipped event named", stderr.String())
+	}
+}
+
+func TestStatusFilter(t *testing.T) {
+	mixed := func() *fakeService {
+		withStatus := func(id, status string) *calendar.Event {
+			item := timedEvent(id, "2024-01-15T09:00:00Z", time.Hour)
+			item.Status = status
+			return item
+		}
+		return serviceWith(
+			withStatus("meeting", "confirmed"),
+			withStatus("hold", "tentative"),
+			withStatus("dropped", "cancelled"),
+			withStatus("review", "confirmed"),
+		)
+	}
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "meeting hold dropped review"},
+		{[]string{"--status", "confirmed"}, "meeting review"},
+		{[]string{"--status", "confirmed,tentative"}, "meeting hold review"},
+		{[]string{"--status", "tentative", "--status", "cancelled", "--show-deleted"}, "hold dropped"},
+	} {
+		if got := strings.Join(listedIDs(t, mixed(), c.args...), " "); got != c.want {
+			t.Errorf("%v listed %s, want %s", c.args, got, c.want)
+		}
+	}
+	for _, args := range [][]string{{"--status", "busy"}, {"--status", "cancelled"}} {
+		if _, err := runCommand(append([]string{"--start", "2024-01-01", "--end", "2024-01-31"}, args...)...); err == nil {
+			t.Errorf("%v succeeded", args)
+		}
+	}
+}
+
+func TestVisibilityFilter(t *testing.T) {
+	mixed := func() *fakeService {
+		withVisibility := func(id, visibility string) *calendar.Event {
+			item := timedEvent(id, "2024-01-15T09:00:00Z", time.Hour)
+			item.Visibility = visibility
+			return item
+		}
+		return serviceWith(
+			withVisibility("standup", ""),
+			withVisibility("launch", "public"),
+			withVisibility("doctor", "private"),
+			withVisibility("review", "confidential"),
+			withVisibility("lunch", "default"),
+		)
+	}
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "standup launch doctor review lunch"},
+		{[]string{"--visibility", "default"}, "standup lunch"},
+		{[]string{"--visibility", "private,confidential"}, "doctor review"},
+	} {
+		if got := strings.Join(listedIDs(t, mixed(), c.args...), " "); got != c.want {
+			t.Errorf("%v listed %s, want %s", c.args, got, c.want)
+		}
+	}
+	if _, err := runCommand("--start", "2024-01-01", "--end", "2024-01-31", "--visibility", "secret"); err == nil {
+		t.Error("--visibility secret succeeded")
+	}
+}
+
+func TestFreeEvents(t *testing.T) {
+	withFree := func() *fakeService {
+		free := timedEvent("focus", "2024-01-15T13:00:00Z", 2*time.Hour)
+		free.Transparency = "transparent"
+		busy := timedEvent("review", "2024-01-15T10:00:00Z", time.Hour)
+		busy.Transparency = "opaque"
+		return serviceWith(timedEvent("standup", "2024-01-15T09:00:00Z", 30*time.Minute), busy, free)
+	}
+	if got := strings.Join(listedIDs(t, withFree(), "--busy-only"), " "); got != "standup review" {
+		t.Errorf("--busy-only listed %s, want standup review", got)
+	}
+	if got := strings.Join(listedIDs(t, withFree()), " "); got != "standup review focus" {
+		t.Errorf("listed %s, want every event without --busy-only", got)
+	}
+
+	defer useService(withFree())()
+	out, err := runCommand("--start", "2024-01-01", "--end", "2024-01-31", "--summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"events: 3\n", "total duration: 1h30m0s\n", "events shown as free (counted as zero): 1\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("--summary wrote:\n%s\nwant it to contain %q", out, want)
+		}
+	}
+	out, err = runCommand("--start", "2024-01-15", "--end", "2024-01-15", "--group-by", "day", "--timezone", "UTC", "--no-header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "2024-01-15,3,1.50\n" {
+		t.Errorf("--group-by day wrote %q, want 3 events and 1.5 busy hours", out)
+	}
+}
+
+func TestCreatorAndOrganizer(t *testing.T) {
+	withPeople := func() *fakeService {
+		var items []*calendar.Event
+		for _, e := range []struct{ id, creator, organizer string }{
+			{"own", "me@example.com", "me@example.com"},
+			{"booked", "Assistant@Example.com", "me@example.com"},
+			{"invite", "ann@example.com", "ann@example.com"},
+			{"unknown", "", ""},
+		} {
+			item := timedEvent(e.id, "2024-01-15T09:00:00Z", time.Hour)
+			if e.creator != "" {
+				item.Creator = &calendar.EventCreator{Email: e.creator}
+				item.Organizer = &calendar.EventOrganizer{Email: e.organizer}
+			}
+			items = append(items, item)
+		}
+		return serviceWith(items...)
+	}
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--creator", "assistant@example.com"}, "booked"},
+		{[]string{"--creator", "me@example.com,ann@example.com"}, "own invite"},
+		{[]string{"--organizer", "me@example.com"}, "own booked"},
+		{[]string{"--organizer", "me@example.com", "--creator", "me@example.com"}, "own"},
+	} {
+		if got := strings.Join(listedIDs(t, withPeople(), c.args...), " "); got != c.want {
+			t.Errorf("%v listed %s, want %s", c.args, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// Event is a fetched event together with the ID of the calendar it came from.
+type Event struct {
+	*calendar.Event
+	Calendar string
+}
+
+// Formatter writes events to an output stream in a particular format.
+type Formatter interface {
+	// WriteEvent writes a single event.
+	WriteEvent(item *Event) error
+	// Flush pushes any buffered output to the underlying writer.
+	Flush() error
+	// Close finishes the output once every event has been written.
+	Close() error
+}
+
+// Options shared by the output formatters.
+type formatOptions struct {
+	// noHeader suppresses the CSV header row.
+	noHeader bool
+	// delimiter separates CSV columns, or is zero for a comma.
+	delimiter rune
+	// htmlFull wraps the HTML table in a complete document.
+	htmlFull bool
+	// keepNewlines writes line breaks within CSV values instead of spaces.
+	keepNewlines bool
+	// jsonPretty indents the objects of the json format.
+	jsonPretty bool
+	// fields are the event fields to write, in order.
+	fields []field
+	// template renders each event for the template format.
+	template *template.Template
+	fieldOptions
+}
+
+// Constructors for each output format, by name.
+var formatters = map[string]func(w io.Writer, opts formatOptions) Formatter{
+	"csv": func(w io.Writer, opts formatOptions) Formatter {
+		return newCSVFormatter(w, opts, opts.delimiter)
+	},
+	"tsv": func(w io.Writer, opts formatOptions) Formatter {
+		return newCSVFormatter(w, opts, '\t')
+	},
+	"json": func(w io.Writer, opts formatOptions) Formatter {
+		return &jsonFormatter{w: w, fields: opts.fields, fieldOpts: opts.rfc3339(), indent: opts.jsonPretty}
+	},
+	"ndjson": func(w io.Writer, opts formatOptions) Formatter {
+		return &ndjsonFormatter{w: w, fields: opts.fields, fieldOpts: opts.rfc3339()}
+	},
+	"ics": func(w io.Writer, opts formatOptions) Formatter {
+		return newICSFormatter(w)
+	},
+	"markdown": func(w io.Writer, opts formatOptions) Formatter {
+		return &markdownFormatter{w: bufio.NewWriter(w), fields: opts.fields, fieldOpts: opts.fieldOptions}
+	},
+	"html": func(w io.Writer, opts formatOptions) Formatter {
+		return &htmlFormatter{w: bufio.NewWriter(w), fields: opts.fields, fieldOpts: opts.fieldOptions, full: opts.htmlFull}
+	},
+	"pretty": func(w io.Writer, opts formatOptions) Formatter {
+		return newPrettyFormatter(w)
+	},
+	"template": func(w io.Writer, opts formatOptions) Formatter {
+		return newTemplateFormatter(w, opts.template)
+	},
+	"xlsx": func(w io.Writer, opts formatOptions) Formatter {
+		return newXLSXFormatter(w, opts)
+	},
+}
+
+// Reports an error unless format names a known output format.
+func checkFormat(format string) error {
+	if _, ok := formatters[format]; ok {
+		return nil
+	}
+	names := make([]string, 0, len(formatters))
+	for name := range formatters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(names, ", "))
+}
+
+// Returns the formatter for the named output format.
+func newFormatter(format string, w io.Writer, opts formatOptions) (Formatter, error) {
+	if err := checkFormat(format); err != nil {
+		return nil, err
+	}
+	return formatters[format](w, opts), nil
+}
+
+// reversedFormatter holds every event until Close, then writes them to the
+// wrapped Formatter in reverse order. The collector's limit bounds how many
+// events it holds.
+type reversedFormatter struct {
+	Formatter
+	events []*Event
+}
+
+func (f *reversedFormatter) WriteEvent(item *Event) error {
+	f.events = append(f.events, item)
+	return nil
+}
+
+func (f *reversedFormatter) Flush() error {
+	return nil
+}
+
+func (f *reversedFormatter) Close() error {
+	for i := len(f.events) - 1; i >= 0; i-- {
+		if err := f.Formatter.WriteEvent(f.events[i]); err != nil {
+			return err
+		}
+	}
+	return f.Formatter.Close()
+}
+
+// Returns a CSV formatter separating columns with comma, or with ',' when
+// comma is zero.
+func newCSVFormatter(w io.Writer, opts formatOptions, comma rune) *csvFormatter {
+	cw := csv.NewWriter(w)
+	if comma != 0 {
+		cw.Comma = comma
+	}
+	return &csvFormatter{w: cw, fields: opts.fields, fieldOpts: opts.fieldOptions, wroteHeader: opts.noHeader, keepNewlines: opts.keepNewlines}
+}
+
+// Parses a --delimiter value: a single character, or \t for a tab. Rejects
+// the characters a CSV writer cannot separate columns with.
+func parseDelimiter(s string) (rune, error) {
+	if s == `\t` || s == "tab" {
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 || size != len(s) {
+		return 0, fmt.Errorf("delimiter %q must be a single character", s)
+	}
+	switch r {
+	case '"', '\r', '\n', utf8.RuneError:
+		return 0, fmt.Errorf("delimiter %q cannot separate CSV columns", s)
+	}
+	return r, nil
+}
+
+type csvFormatter struct {
+	w         *csv.Writer
+	fields    []field
+	fieldOpts fieldOptions
+	// wroteHeader is set once the header row has been written, or from the
+	// start when the header is suppressed.
+	wroteHeader bool
+	// keepNewlines leaves line breaks in values, which are otherwise written
+	// as spaces for parsers that read a row per line.
+	keepNewlines bool
+}
+
+var csvNewlines = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ")
+
+func (f *csvFormatter) writeHeader() error {
+	if f.wroteHeader {
+		return nil
+	}
+	f.wroteHeader = true
+	return f.w.Write(fieldNames(f.fields))
+}
+
+func (f *csvFormatter) WriteEvent(item *Event) error {
+	if err := f.writeHeader(); err != nil {
+		return err
+	}
+	if f.keepNewlines {
+		return WriteEvent(f.w, item, f.fields, &f.fieldOpts)
+	}
+	row := fieldValues(f.fields, item, &f.fieldOpts)
+	for i, v := range row {
+		row[i] = csvNewlines.Replace(v)
+	}
+	return f.w.Write(row)
+}
+
+func (f *csvFormatter) Flush() error {
+	f.w.Flush()
+	return f.w.Error()
+}
+
+func (f *csvFormatter) Close() error {
+	if err := f.writeHeader(); err != nil {
+		return err
+	}
+	return f.Flush()
+}
+
+// jsonFormatter streams events as the elements of a JSON array, one per line,
+// or when indent is set over several lines, indented within the array.
+type jsonFormatter struct {
+	w         io.Writer
+	fields    []field
+	fieldOpts fieldOptions
+	indent    bool
+	count     int
+}
+
+func (f *jsonFormatter) WriteEvent(item *Event) error {
+	b, err := marshalFields(f.fields, item, &f.fieldOpts)
+	if err != nil {
+		return err
+	}
+	if f.indent {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, b, "  ", "  "); err != nil {
+			return err
+		}
+		b = append([]byte("  "), buf.Bytes()...)
+	}
+	sep := ",\n"
+	if f.count == 0 {
+		sep = "[\n"
+	}
+	f.count++
+	_, err = fmt.Fprintf(f.w, "%s%s", sep, b)
+	return err
+}
+
+func (f *jsonFormatter) Flush() error {
+	return nil
+}
+
+func (f *jsonFormatter) Close() error {
+	if f.count == 0 {
+		_, err := io.WriteString(f.w, "[]\n")
+		return err
+	}
+	_, err := io.WriteString(f.w, "\n]\n")
+	return err
+}
+
+// ndjsonFormatter writes each event as a JSON object on its own line,
+// unbuffered so that readers see every event as soon as it is fetched.
+type ndjsonFormatter struct {
+	w         io.Writer
+	fields    []field
+	fieldOpts fieldOptions
+}
+
+func (f *ndjsonFormatter) WriteEvent(item *Event) error {
+	b, err := marshalFields(f.fields, item, &f.fieldOpts)
+	if err != nil {
+		return err
+	}
+	_, err = f.w.Write(append(b, '\n'))
+	return err
+}
+
+func (f *ndjsonFormatter) Flush() error {
+	return nil
+}
+
+func (f *ndjsonFormatter) Close() error {
+	return nil
+}
+
+// Encodes the fields of item as a JSON object, keeping the field order.
+func marshalFields(fields []field, item *Event, o *fieldOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(f.name)
+		if err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if jsonValue, ok := fieldJSONValues[f.name]; ok {
+			v = jsonValue(item, o)
+		} else {
+			v = f.value(item, o)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(b)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Returns the date-time of t, or the date for all-day events.
+func eventTime(t *calendar.EventDateTime) string {
+	if t == nil {
+		return ""
+	}
+	if t.DateTime != "" {
+		return t.DateTime
+	}
+	return t.Date
+}
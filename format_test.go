@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata with the output of the tests")
+
+// Compares got with the golden file testdata/name, rewriting it with
+// -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s, got:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// Returns a timed event with text needing escaping and an all-day event.
+func fixtureEvents() []*Event {
+	meeting := timedEvent("m1", "2024-03-04T09:30:00Z", 90*time.Minute)
+	meeting.Summary = "Planning, Q2; budget"
+	meeting.Description = "Agenda:\n1. Review <roadmap> & risks\n2. Agree on the quarterly priorities for every team in the group"
+	meeting.Location = "Room 4, Building B"
+	meeting.Updated = "2024-02-28T12:00:00Z"
+	holiday := allDayEvent("h1", "2024-03-08", 1)
+	holiday.Summary = "Offsite"
+	holiday.Updated = "2024-02-01T08:00:00Z"
+	return []*Event{{Event: meeting, Calendar: "primary"}, {Event: holiday, Calendar: "primary"}}
+}
+
+// Writes events with the named formatter and returns the output.
+func format(t *testing.T, name string, opts formatOptions, events []*Event) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	f, err := newFormatter(name, &buf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range events {
+		if err := f.WriteEvent(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCSVHeaderOnce(t *testing.T) {
+	fields := mustParseFields(t, "start,summary")
+	for _, c := range []struct {
+		opts formatOptions
+		want []string
+	}{
+		{formatOptions{fields: fields}, []string{"start,summary", "2024-01-01T00:00:00Z,Event e1", "2024-01-01T01:00:00Z,Event e2", "2024-01-01T02:00:00Z,Event e3"}},
+		{formatOptions{fields: fields, noHeader: true}, []string{"2024-01-01T00:00:00Z,Event e1", "2024-01-01T01:00:00Z,Event e2", "2024-01-01T02:00:00Z,Event e3"}},
+	} {
+		out, _, err := collectCSV(&EventCollector{}, c.opts, eventPages(3, 2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := lines(out); strings.Join(got, "\n") != strings.Join(c.want, "\n") {
+			t.Errorf("%+v wrote %q, want %q", c.opts, got, c.want)
+		}
+	}
+}
+
+func TestReverse(t *testing.T) {
+	srv := &fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(4, 2)}}
+	defer useService(srv)()
+	for _, c := range []struct {
+		args []string
+		want []string
+	}{
+		{nil, []string{"id,summary", "e4,Event e4", "e3,Event e3", "e2,Event e2", "e1,Event e1"}},
+		{[]string{"--limit", "3"}, []string{"id,summary", "e3,Event e3", "e2,Event e2", "e1,Event e1"}},
+	} {
+		args := append([]string{"--reverse", "--start", "2024-01-01", "--end", "2024-01-31", "--fields", "id,summary"}, c.args...)
+		out, err := runCommand(args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := lines(out); strings.Join(got, "\n") != strings.Join(c.want, "\n") {
+			t.Errorf("%v wrote %q, want %q", c.args, got, c.want)
+		}
+	}
+}
+
+func TestCSVHeaderWithoutEvents(t *testing.T) {
+	out := format(t, "csv", formatOptions{fields: mustParseFields(t, "start,summary")}, nil)
+	if string(out) != "start,summary\n" {
+		t.Errorf("got %q, want only the header", out)
+	}
+}
+
+func mustParseFields(t *testing.T, s string) []field {
+	t.Helper()
+	fields, err := parseFields(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fields
+}
+
+func TestParseDelimiter(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want rune
+	}{{`\t`, '\t'}, {"tab", '\t'}, {";", ';'}, {"|", '|'}, {"§", '§'}} {
+		got, err := parseDelimiter(c.s)
+		if err != nil || got != c.want {
+			t.Errorf("parseDelimiter(%q) = %q, %v, want %q", c.s, got, err, c.want)
+		}
+	}
+	for _, s := range []string{"", ";;", `"`, "\n", "\r", "\xff"} {
+		if got, err := parseDelimiter(s); err == nil {
+			t.Errorf("parseDelimiter(%q) = %q, want an error", s, got)
+		}
+	}
+}
+
+func TestTabDelimiter(t *testing.T) {
+	opts := formatOptions{fields: mustParseFields(t, "id,summary,location")}
+	out := format(t, "tsv", opts, fixtureEvents()[:1])
+	if want := "id\tsummary\tlocation\nm1\tPlanning, Q2; budget\tRoom 4, Building B\n"; string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	if _, err := runCommand("--format", "tsv", "--delimiter", ";", "--start", "2024-01-01", "--end", "2024-01-31"); err == nil {
+		t.Error("--delimiter with --format tsv succeeded")
+	}
+	if _, err := runCommand("--delimiter", "ab", "--start", "2024-01-01", "--end", "2024-01-31"); err == nil {
+		t.Error("a two-character --delimiter was accepted")
+	}
+}
+
+func TestJSONPretty(t *testing.T) {
+	opts := formatOptions{fields: mustParseFields(t, "id,start,summary")}
+	compact := format(t, "json", opts, fixtureEvents())
+	want := `[
+{"id":"m1","start":"2024-03-04T09:30:00Z","summary":"Planning, Q2; budget"},
+{"id":"h1","start":"2024-03-08","summary":"Offsite"}
+]
+`
+	if string(compact) != want {
+		t.Errorf("compact output is\n%s\nwant\n%s", compact, want)
+	}
+	opts.jsonPretty = true
+	pretty := format(t, "json", opts, fixtureEvents())
+	want = `[
+  {
+    "id": "m1",
+    "start": "2024-03-04T09:30:00Z",
+    "summary": "Planning, Q2; budget"
+  },
+  {
+    "id": "h1",
+    "start": "2024-03-08",
+    "summary": "Offsite"
+  }
+]
+`
+	if string(pretty) != want {
+		t.Errorf("pretty output is\n%s\nwant\n%s", pretty, want)
+	}
+	var a, b interface{}
+	if err := json.Unmarshal(compact, &a); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(pretty, &b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("pretty output holds %v, want the same as compact %v", b, a)
+	}
+	if _, err := runCommand("--format", "ndjson", "--json-pretty", "--start", "2024-01-01", "--end", "2024-01-31"); err == nil {
+		t.Error("--json-pretty with --format ndjson succeeded")
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	opts := formatOptions{fields: mustParseFields(t, "id,start,summary,attendees")}
+	out := format(t, "ndjson", opts, fixtureEvents())
+	got := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	if len(got) != 2 {
+		t.Fatalf("wrote %d lines, want one per event:\n%s", len(got), out)
+	}
+	var fromJSON []json.RawMessage
+	if err := json.Unmarshal(format(t, "json", opts, fixtureEvents()), &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	for i, line := range got {
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Errorf("line %d is not a JSON object: %v", i+1, err)
+		}
+		// Both formats extract the fields the same way.
+		if line != string(fromJSON[i]) {
+			t.Errorf("line %d is %s, the json format wrote %s", i+1, line, fromJSON[i])
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// Fields written for busy periods when --fields is not given.
+const freeBusyFields = "start,end"
+
+// An interval is a span of time from start to end.
+type interval struct {
+	start time.Time
+	end   time.Time
+}
+
+// Returns the intervals sorted by start with those that overlap, or are at
+// most gap apart, merged together.
+func mergeIntervals(ivs []interval, gap time.Duration) []interval {
+	if len(ivs) == 0 {
+		return nil
+	}
+	sorted := append([]interval(nil), ivs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start.Before(sorted[j].start) })
+	merged := []interval{sorted[0]}
+	for _, iv := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if !iv.start.After(last.end.Add(gap)) {
+			if iv.end.After(last.end) {
+				last.end = iv.end
+			}
+			continue
+		}
+		merged = append(merged, iv)
+	}
+	return merged
+}
+
+// Queries the busy periods of the calendars between min and max, and writes
+// them merged into the fewest intervals as events with a start and end.
+// Returns the number of intervals written.
+func writeFreeBusy(ctx context.Context, q BusyQuerier, calendarIDs []string, min, max time.Time, loc *time.Location, f Formatter) (int, error) {
+	resp, err := q.QueryFreeBusy(ctx, calendarIDs, min, max)
+	if err != nil {
+		return 0, err
+	}
+	var failed multiError
+	var busy []interval
+	for _, id := range calendarIDs {
+		cal, ok := resp.Calendars[id]
+		if !ok {
+			failed = append(failed, fmt.Errorf("%s: no free/busy information returned", id))
+			continue
+		}
+		if len(cal.Errors) > 0 {
+			reasons := make([]string, len(cal.Errors))
+			for i, e := range cal.Errors {
+				reasons[i] = e.Reason
+			}
+			failed = append(failed, fmt.Errorf("%s: %s", id, strings.Join(reasons, ", ")))
+			continue
+		}
+		for _, p := range cal.Busy {
+			start, err := time.Parse(time.RFC3339, p.Start)
+			if err != nil {
+				return 0, fmt.Errorf("%s: invalid busy period start %q", id, p.Start)
+			}
+			end, err := time.Parse(time.RFC3339, p.End)
+			if err != nil {
+				return 0, fmt.Errorf("%s: invalid busy period end %q", id, p.End)
+			}
+			busy = append(busy, interval{start, end})
+		}
+	}
+	if len(failed) > 0 {
+		return 0, failed
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+	merged := mergeIntervals(busy, 0)
+	for i, iv := range merged {
+		item := &calendar.Event{
+			Id:      fmt.Sprintf("busy-%d", iv.start.Unix()),
+			Summary: "busy",
+			Start:   &calendar.EventDateTime{DateTime: iv.start.In(loc).Format(time.RFC3339)},
+			End:     &calendar.EventDateTime{DateTime: iv.end.In(loc).Format(time.RFC3339)},
+		}
+		if err := f.WriteEvent(&Event{Event: item}); err != nil {
+			return i, err
+		}
+	}
+	return len(merged), f.Flush()
+}
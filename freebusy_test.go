@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+func TestMergeIntervals(t *testing.T) {
+	at := func(hour, min int) time.Time { return time.Date(2024, 1, 15, hour, min, 0, 0, time.UTC) }
+	for _, c := range []struct {
+		name string
+		ivs  []interval
+		gap  time.Duration
+		want []interval
+	}{
+		{"empty", nil, 0, nil},
+		{"apart", []interval{{at(9, 0), at(10, 0)}, {at(11, 0), at(12, 0)}}, 0, []interval{{at(9, 0), at(10, 0)}, {at(11, 0), at(12, 0)}}},
+		{"overlapping", []interval{{at(9, 0), at(10, 30)}, {at(10, 0), at(11, 0)}}, 0, []interval{{at(9, 0), at(11, 0)}}},
+		{"adjacent", []interval{{at(9, 0), at(10, 0)}, {at(10, 0), at(11, 0)}}, 0, []interval{{at(9, 0), at(11, 0)}}},
+		{"contained", []interval{{at(9, 0), at(12, 0)}, {at(10, 0), at(11, 0)}}, 0, []interval{{at(9, 0), at(12, 0)}}},
+		{"unsorted", []interval{{at(11, 0), at(12, 0)}, {at(9, 0), at(10, 0)}, {at(9, 30), at(11, 0)}}, 0, []interval{{at(9, 0), at(12, 0)}}},
+		{"within gap", []interval{{at(9, 0), at(10, 0)}, {at(10, 15), at(11, 0)}}, 15 * time.Minute, []interval{{at(9, 0), at(11, 0)}}},
+	} {
+		if got := mergeIntervals(c.ivs, c.gap); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// Returns free/busy information with a busy period for each pair of RFC3339
+// times in periods.
+func busyCalendar(periods ...string) calendar.FreeBusyCalendar {
+	var cal calendar.FreeBusyCalendar
+	for i := 0; i+1 < len(periods); i += 2 {
+		cal.Busy = append(cal.Busy, &calendar.TimePeriod{Start: periods[i], End: periods[i+1]})
+	}
+	return cal
+}
+
+func TestFreeBusy(t *testing.T) {
+	srv := &fakeService{busy: map[string]calendar.FreeBusyCalendar{
+		"mine": busyCalendar(
+			"2024-01-15T09:00:00Z", "2024-01-15T10:00:00Z",
+			"2024-01-15T14:00:00Z", "2024-01-15T15:00:00Z",
+		),
+		// Overlaps the first period of mine, then follows it directly.
+		"team": busyCalendar(
+			"2024-01-15T09:30:00Z", "2024-01-15T11:00:00Z",
+			"2024-01-15T11:00:00Z", "2024-01-15T12:00:00Z",
+		),
+	}}
+	defer useService(srv)()
+	args := []string{"--freebusy", "--calendar", "mine,team", "--start", "2024-01-15", "--end", "2024-01-16", "--timezone", "UTC"}
+	out, err := runCommand(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "start,end\n" +
+		"2024-01-15T09:00:00Z,2024-01-15T12:00:00Z\n" +
+		"2024-01-15T14:00:00Z,2024-01-15T15:00:00Z\n"
+	if out != want {
+		t.Errorf("wrote %q, want %q", out, want)
+	}
+	if got := srv.busyWindow[0].Format("2006-01-02"); got != "2024-01-15" {
+		t.Errorf("queried from %s, want the start of the window", got)
+	}
+
+	out, err = runCommand(append(args, "--format", "ndjson", "--fields", "start,end,summary")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `{"start":"2024-01-15T09:00:00Z","end":"2024-01-15T12:00:00Z","summary":"busy"}` + "\n" +
+		`{"start":"2024-01-15T14:00:00Z","end":"2024-01-15T15:00:00Z","summary":"busy"}` + "\n"
+	if out != want {
+		t.Errorf("--format ndjson wrote %q, want %q", out, want)
+	}
+}
+
+func TestFreeBusyCalendarError(t *testing.T) {
+	srv := &fakeService{busy: map[string]calendar.FreeBusyCalendar{
+		"mine": busyCalendar("2024-01-15T09:00:00Z", "2024-01-15T10:00:00Z"),
+		"team": {Errors: []*calendar.Error{{Domain: "global", Reason: "notFound"}}},
+	}}
+	defer useService(srv)()
+	out, err := runCommand("--freebusy", "--calendar", "mine,team,gone", "--start", "2024-01-15", "--end", "2024-01-16")
+	if err == nil {
+		t.Fatalf("wrote %q, want an error", out)
+	}
+	for _, want := range []string{"team: notFound", "gone: no free/busy information returned"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %v, want it to contain %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "mine") {
+		t.Errorf("got error %v, want only the failed calendars reported", err)
+	}
+	if out != "" {
+		t.Errorf("wrote %q, want nothing", out)
+	}
+}
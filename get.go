@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+)
+
+// Prints a single event by ID, as the API returned it or in a chosen format.
+func getEvent(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("calendar get", flag.ContinueOnError)
+	var auth authFlags
+	var calendarID string
+	var eventID string
+	var format string
+	var fieldsString string
+	var fmtOpts formatOptions
+	var retry retryPolicy
+	auth.register(fs)
+	fs.StringVar(&calendarID, "calendar", "primary", "Calendar ID the event is in")
+	fs.StringVar(&eventID, "id", "", "ID of the event, see the id field when listing events")
+	fs.StringVar(&format, "format", "", "Output format: csv, tsv, json, ndjson, ics, markdown, html or pretty; by default every property of the event as indented JSON")
+	fs.StringVar(&fieldsString, "fields", allFields, "Comma-separated fields to write with --format, or all; see the fields command")
+	fs.BoolVar(&fmtOpts.noHeader, "no-header", false, "Omit the CSV header row")
+	fs.IntVar(&retry.maxRetries, "max-retries", 3, "Number of times to retry rate limited or failed API requests")
+	if err := parseFlags(fs, args, stderr); err != nil {
+		return err
+	}
+	if eventID == "" {
+		return errors.New("--id is required")
+	}
+	if format != "" {
+		if format == "template" || format == "xlsx" {
+			return fmt.Errorf("--format %s is not supported by get", format)
+		}
+		if err := checkFormat(format); err != nil {
+			return err
+		}
+		var err error
+		if fmtOpts.fields, err = parseFields(fieldsString); err != nil {
+			return fmt.Errorf("unable to parse fields: %v", err)
+		}
+	}
+
+	srv, err := connect(ctx, &auth, fs, stderr, retry)
+	if err != nil {
+		return err
+	}
+	var formatter Formatter
+	if format != "" {
+		if err := loadColors(ctx, srv, &fmtOpts); err != nil {
+			return fmt.Errorf("unable to look up event colors: %v", err)
+		}
+		if formatter, err = newFormatter(format, stdout, fmtOpts); err != nil {
+			return err
+		}
+	}
+	item, err := srv.GetEvent(ctx, calendarID, eventID)
+	if isNotFound(err) || isGone(err) {
+		return fmt.Errorf("event %s not found in calendar %q, or it was deleted", eventID, calendarID)
+	}
+	if isUnauthorized(err) {
+		return auth.rejected(err)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to retrieve event: %v", err)
+	}
+	if formatter == nil {
+		b, err := json.MarshalIndent(item, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(stdout, "%s\n", b)
+		return err
+	}
+	if err := formatter.WriteEvent(&Event{Event: item, Calendar: calendarID}); err != nil {
+		return fmt.Errorf("unable to write event: %v", err)
+	}
+	if err := formatter.Close(); err != nil && !isBrokenPipe(err) {
+		return fmt.Errorf("unable to write event: %v", err)
+	}
+	return nil
+}
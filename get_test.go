@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+func TestGetEvent(t *testing.T) {
+	item := timedEvent("m1", "2024-03-04T09:30:00Z", time.Hour)
+	item.Summary = "Planning"
+	item.ICalUID = "m1@google.com"
+	srv := &fakeService{
+		pages: map[string][]*calendar.Events{"team": {{Items: []*calendar.Event{timedEvent("other", "2024-03-04T08:00:00Z", time.Hour), item}}}},
+		errs:  map[string]error{"old": &googleapi.Error{Code: http.StatusGone}},
+	}
+	defer useService(srv)()
+
+	out, err := runCommand("get", "--calendar", "team", "--id", "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got calendar.Event
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("wrote %q: %v", out, err)
+	}
+	if got.Id != "m1" || got.Summary != "Planning" || got.ICalUID != "m1@google.com" || got.Start.DateTime != "2024-03-04T09:30:00Z" {
+		t.Errorf("wrote %+v, want the whole event m1", got)
+	}
+	if !strings.HasPrefix(out, "{\n  \"") {
+		t.Errorf("wrote %q, want indented JSON", out)
+	}
+
+	out, err = runCommand("get", "--calendar", "team", "--id", "m1", "--format", "csv", "--fields", "id,summary,calendar", "--no-header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "m1,Planning,team\n" {
+		t.Errorf("wrote %q with --format csv, want the selected fields", out)
+	}
+
+	for _, c := range []struct{ calendar, id string }{{"team", "missing"}, {"old", "m1"}} {
+		_, err := runCommand("get", "--calendar", c.calendar, "--id", c.id)
+		if err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("%s in %s: got error %v, want not found", c.id, c.calendar, err)
+		}
+	}
+	item.ColorId = "11"
+	srv.colors = &calendar.Colors{Event: map[string]calendar.ColorDefinition{"11": {Background: "#dc2127"}}}
+	out, err = runCommand("get", "--calendar", "team", "--id", "m1", "--format", "csv", "--fields", "id,color", "--no-header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "m1,Tomato #dc2127\n" {
+		t.Errorf("wrote %q, want the color named", out)
+	}
+
+	if _, err := runCommand("get"); err == nil || err.Error() != "--id is required" {
+		t.Errorf("got error %v, want --id required", err)
+	}
+}
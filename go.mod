@@ -4,5 +4,7 @@ go 1.12
 
 require (
 	golang.org/x/net v0.0.0-20190628185345-da137c7871d7 // indirect
-	google.golang.org/api v0.7.0 // indirect
+	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
+	google.golang.org/api v0.7.0
+	gopkg.in/yaml.v3 v3.0.1
 )
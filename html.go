@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"html"
+)
+
+// htmlFormatter writes events as an HTML table with a column per field, or
+// as a complete HTML document holding the table. Every value is escaped, so
+// event text cannot inject markup.
+type htmlFormatter struct {
+	w         *bufio.Writer
+	fields    []field
+	fieldOpts fieldOptions
+	full      bool
+	started   bool
+}
+
+func (f *htmlFormatter) begin() {
+	if f.started {
+		return
+	}
+	f.started = true
+	if f.full {
+		f.w.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Calendar events</title>\n</head>\n<body>\n")
+	}
+	f.w.WriteString("<table>\n<thead>\n")
+	f.row("th", fieldNames(f.fields))
+	f.w.WriteString("</thead>\n<tbody>\n")
+}
+
+// Writes a table row of cells of the given element. Write errors are sticky
+// in the bufio.Writer and reported by Flush.
+func (f *htmlFormatter) row(element string, cells []string) {
+	f.w.WriteString("<tr>")
+	for _, c := range cells {
+		f.w.WriteString("<" + element + ">" + html.EscapeString(c) + "</" + element + ">")
+	}
+	f.w.WriteString("</tr>\n")
+}
+
+func (f *htmlFormatter) WriteEvent(item *Event) error {
+	f.begin()
+	f.row("td", fieldValues(f.fields, item, &f.fieldOpts))
+	return nil
+}
+
+func (f *htmlFormatter) Flush() error {
+	return f.w.Flush()
+}
+
+func (f *htmlFormatter) Close() error {
+	f.begin()
+	f.w.WriteString("</tbody>\n</table>\n")
+	if f.full {
+		f.w.WriteString("</body>\n</html>\n")
+	}
+	return f.Flush()
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTMLGolden(t *testing.T) {
+	opts := formatOptions{fields: mustParseFields(t, "start,end,summary,location"), htmlFull: true}
+	checkGolden(t, "events.html", format(t, "html", opts, fixtureEvents()))
+}
+
+func TestHTMLEscapesScript(t *testing.T) {
+	item := timedEvent("x1", "2024-01-15T10:00:00Z", time.Hour)
+	item.Summary = `<script>alert("hi")</script>`
+	out := string(format(t, "html", formatOptions{fields: mustParseFields(t, "summary")}, []*Event{{Event: item}}))
+	if strings.Contains(out, "<script>") {
+		t.Errorf("wrote the script unescaped:\n%s", out)
+	}
+	if want := "<td>&lt;script&gt;alert(&#34;hi&#34;)&lt;/script&gt;</td>"; !strings.Contains(out, want) {
+		t.Errorf("got:\n%s\nwant a cell %s", out, want)
+	}
+	if strings.Contains(out, "<html>") {
+		t.Error("wrote a full document without --html-full")
+	}
+}
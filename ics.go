@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// Maximum length of an iCalendar content line in octets, excluding the CRLF.
+const icsLineLimit = 75
+
+// icsFormatter writes events as an RFC 5545 VCALENDAR with one VEVENT each.
+type icsFormatter struct {
+	w       *bufio.Writer
+	started bool
+}
+
+func newICSFormatter(w io.Writer) *icsFormatter {
+	return &icsFormatter{w: bufio.NewWriter(w)}
+}
+
+func (f *icsFormatter) begin() {
+	if f.started {
+		return
+	}
+	f.started = true
+	f.line("BEGIN:VCALENDAR")
+	f.line("VERSION:2.0")
+	f.line("PRODID:-//TripleDogDare//calendar//EN")
+}
+
+func (f *icsFormatter) WriteEvent(item *Event) error {
+	f.begin()
+	f.line("BEGIN:VEVENT")
+	f.line("UID:" + icsEscape(item.Id))
+	stamp := time.Now()
+	if t, err := time.Parse(time.RFC3339, item.Updated); err == nil {
+		stamp = t
+	}
+	f.line("DTSTAMP:" + stamp.UTC().Format(icsDateTime))
+	if p := icsTime("DTSTART", item.Start); p != "" {
+		f.line(p)
+	}
+	if p := icsTime("DTEND", item.End); p != "" {
+		f.line(p)
+	}
+	if item.Summary != "" {
+		f.line("SUMMARY:" + icsEscape(item.Summary))
+	}
+	if item.Description != "" {
+		f.line("DESCRIPTION:" + icsEscape(item.Description))
+	}
+	if item.Location != "" {
+		f.line("LOCATION:" + icsEscape(item.Location))
+	}
+	if link := meetLink(item); link != "" {
+		f.line("X-GOOGLE-CONFERENCE:" + icsEscape(link))
+	}
+	f.line("END:VEVENT")
+	return nil
+}
+
+func (f *icsFormatter) Flush() error {
+	return f.w.Flush()
+}
+
+func (f *icsFormatter) Close() error {
+	f.begin()
+	f.line("END:VCALENDAR")
+	return f.Flush()
+}
+
+// Writes a folded content line. Write errors are sticky in the bufio.Writer
+// and reported by Flush.
+func (f *icsFormatter) line(s string) {
+	f.w.WriteString(icsFold(s))
+}
+
+const (
+	icsDateTime = "20060102T150405Z"
+	icsDate     = "20060102"
+)
+
+// Formats t as a DTSTART/DTEND property, using a DATE value for all-day events.
+func icsTime(name string, t *calendar.EventDateTime) string {
+	if t == nil {
+		return ""
+	}
+	if t.DateTime != "" {
+		dt, err := time.Parse(time.RFC3339, t.DateTime)
+		if err != nil {
+			return ""
+		}
+		return name + ":" + dt.UTC().Format(icsDateTime)
+	}
+	if t.Date != "" {
+		d, err := time.Parse("2006-01-02", t.Date)
+		if err != nil {
+			return ""
+		}
+		return name + ";VALUE=DATE:" + d.Format(icsDate)
+	}
+	return ""
+}
+
+var icsEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`;`, `\;`,
+	`,`, `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+)
+
+// Escapes a TEXT property value per RFC 5545 section 3.3.11.
+func icsEscape(s string) string {
+	return icsEscaper.Replace(s)
+}
+
+// Folds s into CRLF-terminated lines of at most icsLineLimit octets without
+// splitting multi-byte characters.
+func icsFold(s string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		size := utf8.RuneLen(r)
+		if n+size > icsLineLimit {
+			b.WriteString("\r\n ")
+			n = 1
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestICSGolden(t *testing.T) {
+	checkGolden(t, "events.ics", format(t, "ics", formatOptions{}, fixtureEvents()))
+}
+
+func TestICSRoundTrip(t *testing.T) {
+	events := fixtureEvents()
+	out := string(format(t, "ics", formatOptions{}, events))
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(line) > icsLineLimit {
+			t.Errorf("line of %d octets: %q", len(line), line)
+		}
+	}
+	props := icsProperties(out)
+	if got := props["SUMMARY"]; len(got) != 2 || got[0] != events[0].Summary || got[1] != events[1].Summary {
+		t.Errorf("summaries %q, want those of the events", got)
+	}
+	if got := props["DESCRIPTION"]; len(got) != 1 || got[0] != events[0].Description {
+		t.Errorf("descriptions %q, want %q", got, events[0].Description)
+	}
+	if got := props["DTSTART;VALUE=DATE"]; len(got) != 1 || got[0] != "20240308" {
+		t.Errorf("all-day start %q, want 20240308", got)
+	}
+	if got := props["UID"]; len(got) != 2 || got[0] != "m1" || got[1] != "h1" {
+		t.Errorf("UIDs %q, want m1 and h1", got)
+	}
+}
+
+func TestICSFoldMultibyte(t *testing.T) {
+	folded := icsFold("SUMMARY:" + strings.Repeat("日", 40))
+	for _, line := range strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n") {
+		if len(line) > icsLineLimit {
+			t.Errorf("line of %d octets", len(line))
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("line %q splits a character", line)
+		}
+	}
+}
+
+// Parses an iCalendar stream into the unescaped values of each property,
+// keyed by the property name and its parameters.
+func icsProperties(s string) map[string][]string {
+	unescape := strings.NewReplacer(`\n`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+	props := map[string][]string{}
+	for _, line := range strings.Split(strings.Replace(s, "\r\n ", "", -1), "\r\n") {
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		props[line[:i]] = append(props[line[:i]], unescape.Replace(line[i+1:]))
+	}
+	return props
+}
+
+func TestICSConferenceLink(t *testing.T) {
+	events := []*Event{
+		{Event: conferenceEvent("c1", "https://meet.google.com/abc-defg-hij", "video=https://zoom.us/j/1")},
+		{Event: conferenceEvent("c2", "", "phone=tel:+1-555-0100", "video=https://zoom.us/j/2")},
+		{Event: conferenceEvent("c3", "")},
+	}
+	props := icsProperties(string(format(t, "ics", formatOptions{}, events)))
+	// The event without conference data has no conference line.
+	want := []string{"https://meet.google.com/abc-defg-hij", "https://zoom.us/j/2"}
+	if got := props["X-GOOGLE-CONFERENCE"]; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("conference links %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Lists the occurrences of a recurring event within the time window.
+func listInstances(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("calendar instances", flag.ContinueOnError)
+	var auth authFlags
+	var window windowFlags
+	var calendarID string
+	var eventID string
+	var format string
+	var fieldsString string
+	var fmtOpts formatOptions
+	var timezone string
+	var retry retryPolicy
+	auth.register(fs)
+	window.register(fs)
+	fs.StringVar(&calendarID, "calendar", "primary", "Calendar ID the recurring event is in")
+	fs.StringVar(&eventID, "id", "", "ID of the recurring event, see the id field when listing with --expand-recurring=false")
+	fs.StringVar(&format, "format", "csv", "Output format: csv, tsv, json, ndjson, ics, markdown, html or pretty")
+	fs.StringVar(&fieldsString, "fields", defaultFields, "Comma-separated fields to write, or all; see the fields command")
+	fs.BoolVar(&fmtOpts.noHeader, "no-header", false, "Omit the CSV header row")
+	fs.StringVar(&timezone, "timezone", "", "Convert event times to this timezone, like America/New_York or UTC")
+	fs.IntVar(&retry.maxRetries, "max-retries", 3, "Number of times to retry rate limited or failed API requests")
+	if err := parseFlags(fs, args, stderr); err != nil {
+		return err
+	}
+	if eventID == "" {
+		return errors.New("--id is required")
+	}
+	start, end, err := window.resolve(timeNow())
+	if err != nil {
+		return err
+	}
+	if !end.After(start) {
+		return fmt.Errorf("end date must be after start date: %s -> %s", start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+	if format == "template" || format == "xlsx" {
+		return fmt.Errorf("--format %s is not supported by instances", format)
+	}
+	if fmtOpts.fields, err = parseFields(fieldsString); err != nil {
+		return fmt.Errorf("unable to parse fields: %v", err)
+	}
+	collector := EventCollector{calendar: calendarID}
+	if timezone != "" {
+		if collector.timezone, err = time.LoadLocation(timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q: %v", timezone, err)
+		}
+	}
+	if err := checkFormat(format); err != nil {
+		return err
+	}
+
+	srv, err := connect(ctx, &auth, fs, stderr, retry)
+	if err != nil {
+		return err
+	}
+	if err := loadColors(ctx, srv, &fmtOpts); err != nil {
+		return fmt.Errorf("unable to look up event colors: %v", err)
+	}
+	formatter, err := newFormatter(format, stdout, fmtOpts)
+	if err != nil {
+		return err
+	}
+	err = srv.ListInstances(ctx, calendarID, eventID, start, end, collector.WriteCallback(ctx, formatter))
+	if isUnauthorized(err) {
+		return auth.rejected(err)
+	}
+	if isNotFound(err) {
+		return fmt.Errorf("no event %s in calendar %q, check the ID with --expand-recurring=false", eventID, calendarID)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to retrieve instances: %v", err)
+	}
+	if err := formatter.Close(); err != nil && !isBrokenPipe(err) {
+		return fmt.Errorf("unable to write events: %v", err)
+	}
+	infof("Listed %d occurrences of event %s", collector.itemCounter, eventID)
+	return nil
+}
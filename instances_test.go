@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+func TestInstances(t *testing.T) {
+	srv := &fakeService{instances: map[string][]*calendar.Events{"weekly": eventPages(5, 2)}}
+	defer useService(srv)()
+	out, err := runCommand("instances", "--id", "weekly", "--start", "2024-01-01", "--end", "2024-01-31", "--fields", "id", "--no-header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := lines(out), []string{"e1", "e2", "e3", "e4", "e5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listed %v, want every occurrence across the pages %v", got, want)
+	}
+	window := srv.instanceWindows["weekly"]
+	if start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local); !window[0].Equal(start) {
+		t.Errorf("listed from %v, want %v", window[0], start)
+	}
+	if end := time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local); !window[1].Equal(end) {
+		t.Errorf("listed until %v, want %v", window[1], end)
+	}
+
+	srv.instances["weekly"][0].Items[0].ColorId = "11"
+	srv.colors = &calendar.Colors{Event: map[string]calendar.ColorDefinition{"11": {Background: "#dc2127"}}}
+	out, err = runCommand("instances", "--id", "weekly", "--start", "2024-01-01", "--end", "2024-01-31", "--fields", "id,color", "--no-header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lines(out); len(got) != 5 || got[0] != "e1,Tomato #dc2127" || got[1] != "e2," {
+		t.Errorf("wrote %q, want the color of e1 named", got)
+	}
+
+	_, err = runCommand("instances", "--id", "missing", "--start", "2024-01-01", "--end", "2024-01-31")
+	if err == nil || !strings.Contains(err.Error(), "no event missing") {
+		t.Errorf("got error %v, want the event not found", err)
+	}
+	if _, err := runCommand("instances", "--start", "2024-01-01"); err == nil || err.Error() != "--id is required" {
+		t.Errorf("got error %v, want --id required", err)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Returns the current time. Tests replace it with a fixed clock.
+var timeNow = time.Now
+
+// Loads the time of the previous --since-last-run run saved at path, or the
+// zero time when there is none yet.
+func loadLastRun(path string) (time.Time, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(b)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to parse state file %s: %v", path, err)
+	}
+	return t, nil
+}
+
+// Saves t to path as the time of the last run.
+func saveLastRun(path string, t time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(t.UTC().Format(time.RFC3339Nano)+"\n"), 0600)
+}
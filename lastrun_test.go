@@ -0,0 +1,74 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// Replaces the clock with one returning t until the returned func is called.
+func useClock(t time.Time) func() {
+	saved := timeNow
+	timeNow = func() time.Time { return t }
+	return func() { timeNow = saved }
+}
+
+func TestSinceLastRun(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	stateFile := filepath.Join(dir, "state", "last-run")
+	srv := &fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(1, 10)}}
+	defer useService(srv)()
+	args := []string{"--start", "2024-01-01", "--end", "2024-01-31", "--since-last-run", "--state-file", stateFile}
+
+	first := time.Date(2024, 1, 10, 8, 0, 0, 0, time.UTC)
+	restore := useClock(first)
+	_, err := runCommand(args...)
+	restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.query("primary").updatedMin; !got.IsZero() {
+		t.Errorf("first run listed updated after %v, want the whole window", got)
+	}
+	saved, err := loadLastRun(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.Equal(first) {
+		t.Errorf("saved %v, want the time of the first run %v", saved, first)
+	}
+
+	second := first.Add(24 * time.Hour)
+	restore = useClock(second)
+	_, err = runCommand(args...)
+	restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.query("primary").updatedMin; !got.Equal(first) {
+		t.Errorf("second run listed updated after %v, want the first run %v", got, first)
+	}
+	if saved, _ := loadLastRun(stateFile); !saved.Equal(second) {
+		t.Errorf("saved %v, want the time of the second run %v", saved, second)
+	}
+
+	if _, err := runCommand(append(args, "--updated-after", "yesterday")...); err == nil {
+		t.Error("--since-last-run with --updated-after succeeded")
+	}
+}
+
+func TestSinceLastRunNotSavedOnFailure(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	stateFile := filepath.Join(dir, "last-run")
+	defer useService(&fakeService{})()
+	if _, err := runCommand("--calendar", "nobody", "--start", "2024-01-01", "--end", "2024-01-31", "--since-last-run", "--state-file", stateFile); err == nil {
+		t.Fatal("listing a missing calendar succeeded")
+	}
+	if saved, err := loadLastRun(stateFile); err != nil || !saved.IsZero() {
+		t.Errorf("saved %v (%v) after a failed run, want nothing", saved, err)
+	}
+}
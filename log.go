@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+)
+
+// debugLog receives the --verbose diagnostics, or is nil when they are off.
+var debugLog *log.Logger
+
+// infoOutput receives informational messages, which --quiet discards.
+var infoOutput io.Writer = ioutil.Discard
+
+// Enables --verbose logging to w, or disables it when w is nil.
+func setDebugOutput(w io.Writer) {
+	debugLog = nil
+	if w != nil {
+		debugLog = log.New(w, "debug: ", log.Ltime|log.Lmicroseconds)
+	}
+}
+
+// Logs a --verbose diagnostic. Arguments are not formatted unless enabled.
+func debugf(format string, args ...interface{}) {
+	if debugLog != nil {
+		debugLog.Printf(format, args...)
+	}
+}
+
+// Prints an informational message that is not needed to use the output,
+// like a warning or progress note, unless --quiet is given.
+func infof(format string, args ...interface{}) {
+	fmt.Fprintf(infoOutput, format+"\n", args...)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Google's OAuth token revocation endpoint. Tests replace it with a local
+// server.
+var revokeURL = "https://oauth2.googleapis.com/revoke"
+
+// Revokes the cached OAuth token and removes the token file.
+func logout(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("calendar logout", flag.ContinueOnError)
+	var auth authFlags
+	auth.register(fs)
+	if err := parseFlags(fs, args, stderr); err != nil {
+		return err
+	}
+	path, err := expandHome(auth.tokenPath())
+	if err != nil {
+		return fmt.Errorf("unable to resolve token path: %v", err)
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		infof("No token in %s, already logged out", path)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read token file: %v", err)
+	}
+	var cached cachedToken
+	// A token that cannot be read cannot be used either, so it only needs
+	// removing.
+	decodeErr := json.NewDecoder(f).Decode(&cached)
+	f.Close()
+	if decodeErr == nil {
+		// Revoking the refresh token also revokes the access tokens issued
+		// with it.
+		token := cached.RefreshToken
+		if token == "" {
+			token = cached.AccessToken
+		}
+		if token != "" {
+			if err := revokeToken(ctx, http.DefaultClient, token); err != nil {
+				return fmt.Errorf("unable to revoke token, remove %s to log out without revoking it: %v", path, err)
+			}
+		}
+	}
+	if err := auth.removeToken(); err != nil {
+		return fmt.Errorf("unable to remove token file: %v", err)
+	}
+	infof("Logged out, removed %s", path)
+	return nil
+}
+
+// Asks Google to revoke token. A token the endpoint rejects as invalid, like
+// one that has expired or was revoked already, is not an error.
+func revokeToken(ctx context.Context, client *http.Client, token string) error {
+	req, err := http.NewRequest(http.MethodPost, revokeURL, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusBadRequest:
+		debugf("token was already invalid")
+		return nil
+	}
+	return fmt.Errorf("revocation failed: %s", resp.Status)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Makes logout revoke tokens at a local server answering with status, until
+// the returned function restores the real endpoint. The tokens it was given
+// are added to *revoked.
+func useRevoker(status int, revoked *[]string) func() {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		*revoked = append(*revoked, r.Form.Get("token"))
+		w.WriteHeader(status)
+	}))
+	saved := revokeURL
+	revokeURL = ts.URL
+	return func() {
+		revokeURL = saved
+		ts.Close()
+	}
+}
+
+func TestLogoutRevokesAndRemovesToken(t *testing.T) {
+	for _, status := range []int{http.StatusOK, http.StatusBadRequest} {
+		token, cleanup := tempToken(t)
+		var revoked []string
+		restore := useRevoker(status, &revoked)
+		_, err := runCommand("logout", "--token", token)
+		restore()
+		if err != nil {
+			t.Errorf("status %d: %v", status, err)
+		}
+		if len(revoked) != 1 || revoked[0] != "stale" {
+			t.Errorf("status %d: revoked %q, want the cached token", status, revoked)
+		}
+		if _, statErr := os.Stat(token); !os.IsNotExist(statErr) {
+			t.Errorf("status %d: kept the token file", status)
+		}
+		cleanup()
+	}
+}
+
+func TestLogoutKeepsTokenWhenRevokingFails(t *testing.T) {
+	token, cleanup := tempToken(t)
+	defer cleanup()
+	var revoked []string
+	defer useRevoker(http.StatusServiceUnavailable, &revoked)()
+	if _, err := runCommand("logout", "--token", token); err == nil {
+		t.Error("logout succeeded when revoking failed")
+	}
+	if _, err := os.Stat(token); err != nil {
+		t.Errorf("removed the token that was not revoked: %v", err)
+	}
+}
+
+func TestLogoutWithoutToken(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	var revoked []string
+	defer useRevoker(http.StatusOK, &revoked)()
+	if _, err := runCommand("logout", "--token", filepath.Join(dir, "token.json")); err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 0 {
+		t.Errorf("revoked %q without a token", revoked)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+)
+
+// markdownFormatter writes events as a GitHub-flavored Markdown table with a
+// column per field. Tables always have a header, so --no-header is ignored.
+type markdownFormatter struct {
+	w           *bufio.Writer
+	fields      []field
+	fieldOpts   fieldOptions
+	wroteHeader bool
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`|`, `\|`,
+	"\r\n", "<br>",
+	"\n", "<br>",
+)
+
+func (f *markdownFormatter) header() {
+	if f.wroteHeader {
+		return
+	}
+	f.wroteHeader = true
+	f.row(fieldNames(f.fields))
+	rule := make([]string, len(f.fields))
+	for i := range rule {
+		rule[i] = "---"
+	}
+	f.w.WriteString("| " + strings.Join(rule, " | ") + " |\n")
+}
+
+// Writes a table row, escaping the cells. Write errors are sticky in the
+// bufio.Writer and reported by Flush.
+func (f *markdownFormatter) row(cells []string) {
+	escaped := make([]string, len(cells))
+	for i, c := range cells {
+		escaped[i] = markdownEscaper.Replace(c)
+	}
+	f.w.WriteString("| " + strings.Join(escaped, " | ") + " |\n")
+}
+
+func (f *markdownFormatter) WriteEvent(item *Event) error {
+	f.header()
+	f.row(fieldValues(f.fields, item, &f.fieldOpts))
+	return nil
+}
+
+func (f *markdownFormatter) Flush() error {
+	return f.w.Flush()
+}
+
+func (f *markdownFormatter) Close() error {
+	f.header()
+	return f.Flush()
+}
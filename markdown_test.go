@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMarkdownGolden(t *testing.T) {
+	opts := formatOptions{fields: mustParseFields(t, "start,end,summary,location")}
+	checkGolden(t, "events.md", format(t, "markdown", opts, fixtureEvents()))
+}
+
+func TestMarkdownEscapesPipes(t *testing.T) {
+	item := timedEvent("p1", "2024-01-15T10:00:00Z", time.Hour)
+	item.Summary = `a | b \ c`
+	out := format(t, "markdown", formatOptions{fields: mustParseFields(t, "summary")}, []*Event{{Event: item}})
+	if want := "| summary |\n| --- |\n| a \\| b \\\\ c |\n"; string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
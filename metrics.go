@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// metrics receives the counters served on --metrics-listen, or is nil when
+// they are off, making every recording a no-op.
+var metrics *metricSet
+
+// Upper bounds in seconds of the fetch latency histogram buckets.
+var fetchLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricSet counts the work of a long-running command for Prometheus.
+type metricSet struct {
+	mu         sync.Mutex
+	pages      int64
+	events     int64
+	syncResets int64
+	// apiErrors counts failed API requests by HTTP status code.
+	apiErrors map[int]int64
+	// latencyCounts counts page fetches by latency bucket, the last bucket
+	// holding those slower than every bound.
+	latencyCounts []int64
+	latencySum    float64
+	latencyCount  int64
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{apiErrors: map[int]int64{}, latencyCounts: make([]int64, len(fetchLatencyBuckets)+1)}
+}
+
+// Counts a page of events passed on from the API.
+func (m *metricSet) pageFetched() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.pages++
+	m.mu.Unlock()
+}
+
+// Counts an event written to the output.
+func (m *metricSet) eventWritten() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.events++
+	m.mu.Unlock()
+}
+
+// Counts a full sync done because the API expired the sync token.
+func (m *metricSet) syncReset() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.syncResets++
+	m.mu.Unlock()
+}
+
+// Counts an API request that failed with status code.
+func (m *metricSet) apiError(code int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.apiErrors[code]++
+	m.mu.Unlock()
+}
+
+// Records how long fetching a page took, including retries.
+func (m *metricSet) observeFetch(d time.Duration) {
+	if m == nil {
+		return
+	}
+	secs := d.Seconds()
+	i := sort.SearchFloat64s(fetchLatencyBuckets, secs)
+	m.mu.Lock()
+	m.latencyCounts[i]++
+	m.latencySum += secs
+	m.latencyCount++
+	m.mu.Unlock()
+}
+
+// Writes the metrics in the Prometheus text format.
+func (m *metricSet) write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := &metricPrinter{w: w}
+	p.header("calendar_pages_fetched_total", "counter", "Pages of events fetched from the API.")
+	p.printf("calendar_pages_fetched_total %d\n", m.pages)
+	p.header("calendar_events_written_total", "counter", "Events written to the output.")
+	p.printf("calendar_events_written_total %d\n", m.events)
+	p.header("calendar_sync_resets_total", "counter", "Full syncs done because the sync token expired.")
+	p.printf("calendar_sync_resets_total %d\n", m.syncResets)
+	p.header("calendar_api_errors_total", "counter", "Failed API requests by HTTP status code.")
+	codes := make([]int, 0, len(m.apiErrors))
+	for code := range m.apiErrors {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		p.printf("calendar_api_errors_total{code=\"%d\"} %d\n", code, m.apiErrors[code])
+	}
+	p.header("calendar_fetch_duration_seconds", "histogram", "Time taken to fetch a page of events, including retries.")
+	var cumulative int64
+	for i, bound := range fetchLatencyBuckets {
+		cumulative += m.latencyCounts[i]
+		p.printf("calendar_fetch_duration_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	p.printf("calendar_fetch_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.latencyCount)
+	p.printf("calendar_fetch_duration_seconds_sum %s\n", strconv.FormatFloat(m.latencySum, 'g', -1, 64))
+	p.printf("calendar_fetch_duration_seconds_count %d\n", m.latencyCount)
+	return p.err
+}
+
+func (m *metricSet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+// metricPrinter writes lines to w, keeping the first error.
+type metricPrinter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *metricPrinter) header(name, kind, help string) {
+	p.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (p *metricPrinter) printf(format string, args ...interface{}) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+func TestMetricsDisabled(t *testing.T) {
+	var m *metricSet
+	m.pageFetched()
+	m.eventWritten()
+	m.syncReset()
+	m.apiError(500)
+	m.observeFetch(time.Second)
+}
+
+func TestMetricsWritten(t *testing.T) {
+	m := newMetricSet()
+	m.apiError(429)
+	m.apiError(429)
+	m.apiError(500)
+	m.syncReset()
+	m.observeFetch(80 * time.Millisecond)
+	m.observeFetch(3 * time.Second)
+	m.observeFetch(time.Minute)
+	var buf bytes.Buffer
+	if err := m.write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# TYPE calendar_pages_fetched_total counter\ncalendar_pages_fetched_total 0\n",
+		"calendar_sync_resets_total 1\n",
+		"calendar_api_errors_total{code=\"429\"} 2\ncalendar_api_errors_total{code=\"500\"} 1\n",
+		"calendar_fetch_duration_seconds_bucket{le=\"0.05\"} 0\ncalendar_fetch_duration_seconds_bucket{le=\"0.1\"} 1\n",
+		"calendar_fetch_duration_seconds_bucket{le=\"5\"} 2\ncalendar_fetch_duration_seconds_bucket{le=\"10\"} 2\n",
+		"calendar_fetch_duration_seconds_bucket{le=\"+Inf\"} 3\n",
+		"calendar_fetch_duration_seconds_count 3\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics missing %q in:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestMetricsRecorded(t *testing.T) {
+	saved := metrics
+	metrics = newMetricSet()
+	defer func() { metrics = saved }()
+	c := EventCollector{}
+	if err := fetchEvents(context.Background(), &fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(5, 2)}}, "primary", januaryQuery(), c.WriteCallback(context.Background(), &eventBuffer{})); err != nil {
+		t.Fatal(err)
+	}
+	var delays []time.Duration
+	defer recordSleeps(&delays)()
+	retryPolicy{maxRetries: 1}.do(context.Background(), func() error { return &googleapi.Error{Code: 503} })
+	if metrics.pages != 3 || metrics.events != 5 || metrics.apiErrors[503] != 2 {
+		t.Errorf("recorded %d pages, %d events and %d errors, want 3, 5 and 2", metrics.pages, metrics.events, metrics.apiErrors[503])
+	}
+}
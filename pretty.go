@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ANSI escape sequences used by the pretty format.
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1m"
+	ansiDim   = "\x1b[2m"
+	ansiToday = "\x1b[1;33m"
+)
+
+// prettyFormatter writes events for reading in a terminal: a header for each
+// day followed by a line per event with aligned times and durations. The
+// events of a day are held until the day is complete so that they line up.
+type prettyFormatter struct {
+	w     *bufio.Writer
+	color bool
+	now   time.Time
+	day   string
+	rows  []prettyRow
+}
+
+type prettyRow struct {
+	when     string
+	duration string
+	text     string
+	past     bool
+	today    bool
+}
+
+// Returns a pretty formatter, using colors when w is a terminal and the
+// NO_COLOR environment variable is not set.
+func newPrettyFormatter(w io.Writer) *prettyFormatter {
+	return &prettyFormatter{w: bufio.NewWriter(w), color: isTerminal(w) && os.Getenv("NO_COLOR") == "", now: timeNow()}
+}
+
+// Reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (f *prettyFormatter) WriteEvent(item *Event) error {
+	start, end, allDay, _ := eventInterval(item.Event)
+	day := "No date"
+	if !start.IsZero() {
+		day = start.Format("Mon Jan 2 2006")
+	}
+	if day != f.day {
+		f.writeDay()
+		f.day = day
+	}
+	row := prettyRow{text: item.Summary}
+	if allDay {
+		row.when = "all day"
+	} else if !start.IsZero() {
+		row.when = start.Format("15:04")
+		if !end.IsZero() {
+			row.when += "-" + end.Format("15:04")
+		}
+	}
+	if d, ok := eventDuration(item); ok && !allDay {
+		row.duration = formatDuration(d)
+	} else if ok && d > 24*time.Hour {
+		row.duration = fmt.Sprintf("%d days", d/(24*time.Hour))
+	}
+	if item.Location != "" {
+		row.text += "  @ " + item.Location
+	}
+	row.past = !end.IsZero() && end.Before(f.now)
+	row.today = !start.IsZero() && startOfDay(start).Equal(startOfDay(f.now.In(start.Location())))
+	f.rows = append(f.rows, row)
+	return nil
+}
+
+// Writes the header and the aligned events of the pending day.
+func (f *prettyFormatter) writeDay() {
+	if len(f.rows) == 0 {
+		return
+	}
+	whenWidth, durationWidth := 0, 0
+	for _, r := range f.rows {
+		if n := utf8.RuneCountInString(r.when); n > whenWidth {
+			whenWidth = n
+		}
+		if n := utf8.RuneCountInString(r.duration); n > durationWidth {
+			durationWidth = n
+		}
+	}
+	f.line(f.day, ansiBold)
+	for _, r := range f.rows {
+		s := "  " + pad(r.when, whenWidth) + "  " + pad(r.duration, durationWidth) + "  " + r.text
+		switch {
+		case r.past:
+			f.line(s, ansiDim)
+		case r.today:
+			f.line(s, ansiToday)
+		default:
+			f.line(s, "")
+		}
+	}
+	f.rows = f.rows[:0]
+}
+
+// Writes s on a line in the given color, when colors are enabled. Write
+// errors are sticky in the bufio.Writer and reported by Flush.
+func (f *prettyFormatter) line(s, color string) {
+	if f.color && color != "" {
+		s = color + s + ansiReset
+	}
+	f.w.WriteString(s + "\n")
+}
+
+// Pads s with spaces to width characters.
+func pad(s string, width int) string {
+	return s + strings.Repeat(" ", width-utf8.RuneCountInString(s))
+}
+
+// Formats d in hours and minutes, like 1h30m or 45m.
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	h, m := d/time.Hour, (d%time.Hour)/time.Minute
+	switch {
+	case h == 0:
+		return fmt.Sprintf("%dm", m)
+	case m == 0:
+		return fmt.Sprintf("%dh", h)
+	}
+	return fmt.Sprintf("%dh%dm", h, m)
+}
+
+// Flushes the completed days. The current day is held back until its last
+// event is known.
+func (f *prettyFormatter) Flush() error {
+	return f.w.Flush()
+}
+
+func (f *prettyFormatter) Close() error {
+	f.writeDay()
+	return f.Flush()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// Returns events around noon on Mon Jan 15 2024, local time: one the day
+// before, four that day, of which one is over and one is under way, and a
+// two-day event after.
+func prettyEvents() []*Event {
+	at := func(day, hour, min int) string {
+		return time.Date(2024, 1, day, hour, min, 0, 0, time.Local).Format(time.RFC3339)
+	}
+	timed := func(id, summary, start, end string) *Event {
+		return &Event{Event: &calendar.Event{
+			Id:      id,
+			Summary: summary,
+			Start:   &calendar.EventDateTime{DateTime: start},
+			End:     &calendar.EventDateTime{DateTime: end},
+		}}
+	}
+	lunch := timed("e4", "Lunch", at(15, 11, 30), at(15, 13, 0))
+	lunch.Location = "Cafeteria"
+	return []*Event{
+		timed("e1", "Retro", at(14, 16, 0), at(14, 17, 0)),
+		{Event: allDayEvent("e2", "2024-01-15", 1)},
+		timed("e3", "Standup", at(15, 9, 0), at(15, 9, 15)),
+		lunch,
+		timed("e5", "Planning", at(15, 15, 0), at(15, 16, 30)),
+		{Event: allDayEvent("e6", "2024-01-16", 2)},
+	}
+}
+
+// Writes events with f and returns the output.
+func writePretty(t *testing.T, f *prettyFormatter, out *bytes.Buffer, events []*Event) string {
+	t.Helper()
+	for _, item := range events {
+		if err := f.WriteEvent(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestPrettyFormat(t *testing.T) {
+	defer useClock(time.Date(2024, 1, 15, 12, 0, 0, 0, time.Local))()
+	var out bytes.Buffer
+	got := writePretty(t, newPrettyFormatter(&out), &out, prettyEvents())
+	want := strings.Join([]string{
+		"Sun Jan 14 2024",
+		"  16:00-17:00  1h  Retro",
+		"Mon Jan 15 2024",
+		"  all day             Event e2",
+		"  09:00-09:15  15m    Standup",
+		"  11:30-13:00  1h30m  Lunch  @ Cafeteria",
+		"  15:00-16:30  1h30m  Planning",
+		"Tue Jan 16 2024",
+		"  all day  2 days  Event e6",
+		"",
+	}, "\n")
+	if got != want {
+		t.Errorf("wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPrettyFormatColors(t *testing.T) {
+	defer useClock(time.Date(2024, 1, 15, 12, 0, 0, 0, time.Local))()
+	var out bytes.Buffer
+	f := newPrettyFormatter(&out)
+	f.w, f.color = bufio.NewWriter(&out), true
+	got := writePretty(t, f, &out, prettyEvents())
+	// Days are bold, events that are over dim, and the rest of today's
+	// events highlighted.
+	want := strings.Join([]string{
+		ansiBold + "Sun Jan 14 2024" + ansiReset,
+		ansiDim + "  16:00-17:00  1h  Retro" + ansiReset,
+		ansiBold + "Mon Jan 15 2024" + ansiReset,
+		ansiToday + "  all day             Event e2" + ansiReset,
+		ansiDim + "  09:00-09:15  15m    Standup" + ansiReset,
+		ansiToday + "  11:30-13:00  1h30m  Lunch  @ Cafeteria" + ansiReset,
+		ansiToday + "  15:00-16:30  1h30m  Planning" + ansiReset,
+		ansiBold + "Tue Jan 16 2024" + ansiReset,
+		"  all day  2 days  Event e6",
+		"",
+	}, "\n")
+	if got != want {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+}
+
+func TestPrettyFormatColorsOnlyOnTerminal(t *testing.T) {
+	// Character devices count as terminals.
+	tty, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tty.Close()
+	defer setenv("NO_COLOR", "")()
+	if !newPrettyFormatter(tty).color {
+		t.Error("no colors on a terminal")
+	}
+	if newPrettyFormatter(&bytes.Buffer{}).color {
+		t.Error("colors when not writing to a terminal")
+	}
+	defer setenv("NO_COLOR", "1")()
+	if newPrettyFormatter(tty).color {
+		t.Error("colors on a terminal with NO_COLOR set")
+	}
+}
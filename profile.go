@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Directory holding a subdirectory of cached credentials per --profile.
+var profilesDir = "~/.config/calendar"
+
+// profileName is a --profile value, naming a directory in profilesDir.
+type profileName string
+
+func (p *profileName) String() string {
+	return string(*p)
+}
+
+func (p *profileName) Set(s string) error {
+	if s == "" || s == "." || s == ".." || strings.ContainsAny(s, `/\`) {
+		return fmt.Errorf("invalid profile name %q", s)
+	}
+	*p = profileName(s)
+	return nil
+}
+
+// Returns the path of the file called name in the directory of profile.
+func profileFile(profile profileName, name string) string {
+	return filepath.Join(profilesDir, string(profile), name)
+}
+
+// Prints the profiles that have a cached token.
+func listProfiles(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("calendar list-profiles", flag.ContinueOnError)
+	if err := parseFlags(fs, args, stderr); err != nil {
+		return err
+	}
+	dir, err := expandHome(profilesDir)
+	if err != nil {
+		return fmt.Errorf("unable to resolve profiles directory: %v", err)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to list profiles: %v", err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		_, err := os.Stat(filepath.Join(dir, e.Name(), "token.json"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to list profiles: %v", err)
+		}
+		fmt.Fprintln(stdout, e.Name())
+	}
+	return nil
+}
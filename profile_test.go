@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfilesHaveDistinctTokens(t *testing.T) {
+	work := authFlags{profile: "work"}
+	personal := authFlags{profile: "personal"}
+	if work.tokenPath() == personal.tokenPath() {
+		t.Errorf("both profiles use %s", work.tokenPath())
+	}
+	if got, want := work.tokenPath(), filepath.Join(profilesDir, "work", "token.json"); got != want {
+		t.Errorf("work token %s, want %s", got, want)
+	}
+	if got := (&authFlags{}).tokenPath(); got != "token.json" {
+		t.Errorf("without a profile got %s, want token.json", got)
+	}
+	if got := (&authFlags{profile: "work", token: "mine.json"}).tokenPath(); got != "mine.json" {
+		t.Errorf("with --token got %s, want mine.json", got)
+	}
+}
+
+func TestProfileNameValidated(t *testing.T) {
+	for _, name := range []string{"", ".", "..", "a/b", `a\b`} {
+		var p profileName
+		if err := p.Set(name); err == nil {
+			t.Errorf("profile %q was accepted", name)
+		}
+	}
+}
+
+func TestListProfiles(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	saved := profilesDir
+	profilesDir = dir
+	defer func() { profilesDir = saved }()
+	for _, name := range []string{"personal", "work", "empty"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if name == "empty" {
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name, "token.json"), []byte(`{}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, err := runCommand("list-profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "personal\nwork\n" {
+		t.Errorf("listed %q, want the profiles with a token", out)
+	}
+}
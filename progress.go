@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// progressLine reports how many events and pages have been fetched so far,
+// as a line rewritten in place on a terminal and as a line per page
+// elsewhere. The collectors of every calendar share it. A nil progressLine
+// reports nothing.
+type progressLine struct {
+	w        io.Writer
+	terminal bool
+
+	mu     sync.Mutex
+	pages  int
+	events int
+}
+
+func newProgressLine(w io.Writer) *progressLine {
+	return &progressLine{w: w, terminal: isTerminal(w)}
+}
+
+// Adds a page of n events to the totals and reports them.
+func (p *progressLine) page(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pages++
+	p.events += n
+	msg := fmt.Sprintf("fetched %d events (page %d)...", p.events, p.pages)
+	if p.terminal {
+		// Return to the start of the line and clear what is left of the
+		// previous report.
+		fmt.Fprintf(p.w, "\r%s\x1b[K", msg)
+		return
+	}
+	fmt.Fprintln(p.w, msg)
+}
+
+// Clears the line on a terminal, so that the messages after it start on a
+// blank line.
+func (p *progressLine) done() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.terminal && p.pages > 0 {
+		fmt.Fprint(p.w, "\r\x1b[K")
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+func TestProgressPerPage(t *testing.T) {
+	var stderr bytes.Buffer
+	c := EventCollector{progress: newProgressLine(&stderr)}
+	write := c.WriteCallback(context.Background(), &eventBuffer{})
+	for _, page := range eventPages(5, 2) {
+		if err := write(page); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.progress.done()
+	want := "fetched 2 events (page 1)...\nfetched 4 events (page 2)...\nfetched 5 events (page 3)...\n"
+	if stderr.String() != want {
+		t.Errorf("reported %q, want a line per page %q", stderr.String(), want)
+	}
+}
+
+func TestProgressFlag(t *testing.T) {
+	defer useService(&fakeService{pages: map[string][]*calendar.Events{"primary": eventPages(3, 2)}})()
+	var stdout, stderr bytes.Buffer
+	if err := run(context.Background(), []string{"--start", "2024-01-01", "--end", "2024-01-31", "--fields", "id", "--no-header", "--progress"}, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "e1\ne2\ne3\n" {
+		t.Errorf("wrote %q to stdout, want only the events", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "fetched 3 events (page 2)...\n") {
+		t.Errorf("stderr is %q, want the progress of each page", stderr.String())
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Delay before the first retry; each further retry doubles it.
+const retryBaseDelay = 500 * time.Millisecond
+
+// retryPolicy retries transient API failures with exponential backoff.
+type retryPolicy struct {
+	// maxRetries is the number of attempts made after the first one fails.
+	maxRetries int
+}
+
+// Calls fn until it succeeds, fails permanently, runs out of retries, or the
+// next wait would run past the deadline of ctx.
+func (p retryPolicy) do(ctx context.Context, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if apiErr, ok := err.(*googleapi.Error); ok {
+			metrics.apiError(apiErr.Code)
+		}
+		if err == nil || attempt >= p.maxRetries || !isTransient(err) {
+			return err
+		}
+		delay := retryAfter(err)
+		if delay == 0 {
+			delay = backoff(attempt)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+		debugf("retrying in %v after attempt %d failed: %v", delay.Round(time.Millisecond), attempt+1, err)
+		if !retrySleep(ctx, delay) {
+			return err
+		}
+	}
+}
+
+// Waits d unless ctx is done first, reporting whether it waited d. Tests
+// replace it to retry without waiting.
+var retrySleep = func(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// Returns the wait before retry attempt+1: the doubled base delay with up to
+// half of it replaced by random jitter.
+func backoff(attempt int) time.Duration {
+	d := retryBaseDelay << uint(attempt)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Reports whether err is a rate limit or server error worth retrying.
+// Authorization and not found errors are never retried.
+func isTransient(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	if !ok {
+		return false
+	}
+	switch apiErr.Code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusForbidden:
+		for _, item := range apiErr.Errors {
+			if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the wait requested by a Retry-After header on err, or zero.
+func retryAfter(err error) time.Duration {
+	apiErr, ok := err.(*googleapi.Error)
+	if !ok || apiErr.Header == nil {
+		return 0
+	}
+	v := apiErr.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
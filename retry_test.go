@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Makes retries record their delays in *delays instead of waiting, until the
+// returned function restores the real wait.
+func recordSleeps(delays *[]time.Duration) func() {
+	saved := retrySleep
+	retrySleep = func(ctx context.Context, d time.Duration) bool {
+		*delays = append(*delays, d)
+		return true
+	}
+	return func() { retrySleep = saved }
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want bool
+	}{
+		{&googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{&googleapi.Error{Code: http.StatusInternalServerError}, true},
+		{&googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+		{&googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, true},
+		{&googleapi.Error{Code: http.StatusBadRequest}, false},
+		{&googleapi.Error{Code: http.StatusUnauthorized}, false},
+		{&googleapi.Error{Code: http.StatusForbidden}, false},
+		{&googleapi.Error{Code: http.StatusNotFound}, false},
+		{errors.New("connection reset"), false},
+	} {
+		if got := isTransient(c.err); got != c.want {
+			t.Errorf("isTransient(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+// Returns a function failing with err the first failures times it is called,
+// counting its calls in *calls.
+func failing(err error, failures int, calls *int) func() error {
+	return func() error {
+		*calls++
+		if *calls <= failures {
+			return err
+		}
+		return nil
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	var delays []time.Duration
+	defer recordSleeps(&delays)()
+	calls := 0
+	err := (retryPolicy{maxRetries: 3}).do(context.Background(), failing(&googleapi.Error{Code: http.StatusServiceUnavailable}, 2, &calls))
+	if err != nil || calls != 3 {
+		t.Fatalf("got %v after %d calls, want success on the third", err, calls)
+	}
+	for i, d := range delays {
+		if base := retryBaseDelay << uint(i); d < base/2 || d > base {
+			t.Errorf("retry %d waited %v, want between %v and %v", i+1, d, base/2, base)
+		}
+	}
+	if len(delays) != 2 {
+		t.Errorf("waited %d times, want 2", len(delays))
+	}
+}
+
+func TestRetryAfterOverridesBackoff(t *testing.T) {
+	var delays []time.Duration
+	defer recordSleeps(&delays)()
+	limited := &googleapi.Error{Code: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"7"}}}
+	calls := 0
+	if err := (retryPolicy{maxRetries: 3}).do(context.Background(), failing(limited, 2, &calls)); err != nil {
+		t.Fatal(err)
+	}
+	if len(delays) != 2 || delays[0] != 7*time.Second || delays[1] != 7*time.Second {
+		t.Errorf("waited %v, want 7s twice", delays)
+	}
+}
+
+func TestRetryStops(t *testing.T) {
+	var delays []time.Duration
+	defer recordSleeps(&delays)()
+	unavailable := &googleapi.Error{Code: http.StatusServiceUnavailable}
+	for _, c := range []struct {
+		name  string
+		err   error
+		calls int
+	}{
+		{"not found", &googleapi.Error{Code: http.StatusNotFound}, 1},
+		{"unauthorized", &googleapi.Error{Code: http.StatusUnauthorized}, 1},
+		{"out of retries", unavailable, 3},
+	} {
+		calls := 0
+		if err := (retryPolicy{maxRetries: 2}).do(context.Background(), failing(c.err, 10, &calls)); err != c.err {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.err)
+		}
+		if calls != c.calls {
+			t.Errorf("%s: %d calls, want %d", c.name, calls, c.calls)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	delays = nil
+	calls := 0
+	limited := &googleapi.Error{Code: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"60"}}}
+	if err := (retryPolicy{maxRetries: 3}).do(ctx, failing(limited, 10, &calls)); err != limited || calls != 1 || len(delays) != 0 {
+		t.Errorf("got %v after %d calls and waits %v, want to give up before waiting past the deadline", err, calls, delays)
+	}
+}
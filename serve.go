@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Receives the push notifications of a channel created by watch, and on each
+// one writes the events changed since the previous sync to stdout.
+func serveNotifications(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("calendar serve", flag.ContinueOnError)
+	var auth authFlags
+	var listen string
+	var metricsListen string
+	var secret string
+	var calendarID string
+	var syncStatePath string
+	var format string
+	var fieldsString string
+	var fmtOpts formatOptions
+	var retry retryPolicy
+	auth.register(fs)
+	fs.StringVar(&listen, "listen", ":8080", "Address to receive notifications on, behind the public HTTPS endpoint given to watch")
+	fs.StringVar(&metricsListen, "metrics-listen", "", "Address to serve Prometheus metrics on at /metrics, off when empty")
+	fs.StringVar(&secret, "channel-token", "", "Secret given to watch, rejecting notifications without it")
+	fs.StringVar(&calendarID, "calendar", "primary", "Calendar ID the channel watches")
+	fs.StringVar(&syncStatePath, "sync-state", "", "File storing the sync token, so each notification only lists changed events")
+	fs.StringVar(&format, "format", "ndjson", "Output format: csv, tsv, json, ndjson, ics, markdown, html or pretty")
+	fs.StringVar(&fieldsString, "fields", defaultFields, "Comma-separated fields to write, or all; see the fields command")
+	fs.BoolVar(&fmtOpts.noHeader, "no-header", false, "Omit the CSV header row")
+	fs.IntVar(&retry.maxRetries, "max-retries", 3, "Number of times to retry rate limited or failed API requests")
+	if err := parseFlags(fs, args, stderr); err != nil {
+		return err
+	}
+	if secret == "" {
+		return errors.New("--channel-token is required")
+	}
+	if syncStatePath == "" {
+		return errors.New("--sync-state is required")
+	}
+	var err error
+	if syncStatePath, err = expandHome(syncStatePath); err != nil {
+		return fmt.Errorf("unable to resolve sync state path: %v", err)
+	}
+	state, err := loadSyncState(syncStatePath)
+	if err != nil {
+		return err
+	}
+	if format == "template" || format == "xlsx" {
+		return fmt.Errorf("--format %s is not supported by serve", format)
+	}
+	if fmtOpts.fields, err = parseFields(fieldsString); err != nil {
+		return fmt.Errorf("unable to parse fields: %v", err)
+	}
+	formatter, err := newFormatter(format, stdout, fmtOpts)
+	if err != nil {
+		return err
+	}
+
+	lister, err := connect(ctx, &auth, fs, stderr, retry)
+	if err != nil {
+		return err
+	}
+	if metricsListen != "" {
+		metrics = newMetricSet()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		metricsServer := &http.Server{Addr: metricsListen, Handler: mux}
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				infof("Unable to serve metrics: %v", err)
+			}
+		}()
+		defer metricsServer.Close()
+		infof("Serving metrics on %s/metrics", metricsListen)
+	}
+	s := &eventSyncer{lister: lister, calendarID: calendarID, state: state, path: syncStatePath, formatter: formatter}
+	server := &http.Server{Addr: listen, Handler: notificationHandler{secret: secret, sync: s.sync}}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	infof("Listening for notifications on %s", listen)
+	err = server.ListenAndServe()
+	if closeErr := formatter.Close(); closeErr != nil && !isBrokenPipe(closeErr) {
+		return fmt.Errorf("unable to write events: %v", closeErr)
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// notificationHandler answers push notifications, calling sync for each
+// change. Notifications without the channel's secret token are rejected.
+type notificationHandler struct {
+	secret string
+	sync   func(ctx context.Context) error
+}
+
+func (h notificationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := r.Header.Get("X-Goog-Channel-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.secret)) != 1 {
+		infof("Rejected a notification with an invalid channel token from %s", r.RemoteAddr)
+		http.Error(w, "Invalid channel token", http.StatusForbidden)
+		return
+	}
+	// The first notification of a channel only confirms that it works.
+	state := r.Header.Get("X-Goog-Resource-State")
+	if state == "sync" {
+		debugf("channel %s is ready", r.Header.Get("X-Goog-Channel-ID"))
+		return
+	}
+	debugf("notification %s of channel %s: %s", r.Header.Get("X-Goog-Message-Number"), r.Header.Get("X-Goog-Channel-ID"), state)
+	if err := h.sync(r.Context()); err != nil {
+		infof("Unable to sync events: %v", err)
+		// The API sends the notification again after an error status.
+		http.Error(w, "Sync failed", http.StatusInternalServerError)
+	}
+}
+
+// eventSyncer writes the events of a calendar changed since its saved sync
+// token, one sync at a time.
+type eventSyncer struct {
+	lister     EventLister
+	calendarID string
+	state      syncState
+	path       string
+	formatter  Formatter
+
+	mu sync.Mutex
+}
+
+// Fetches and writes the changed events, then saves the new sync token.
+func (s *eventSyncer) sync(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start := time.Now()
+	collector := EventCollector{calendar: s.calendarID}
+	query := eventQuery{maxResults: maxResults, sync: true, syncToken: s.state[s.calendarID]}
+	if err := fetchSync(ctx, s.lister, s.calendarID, query, collector.WriteCallback(ctx, s.formatter)); err != nil {
+		return err
+	}
+	debugf("synced %d events in %v", collector.itemCounter, time.Since(start).Round(time.Millisecond))
+	s.state[s.calendarID] = collector.nextSyncToken
+	if err := s.state.save(s.path); err != nil {
+		return fmt.Errorf("unable to save sync state: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// Sends a notification with the channel token and resource state to h and
+// returns the response status.
+func notify(h http.Handler, token, state string) int {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("X-Goog-Channel-ID", "chan-1")
+	r.Header.Set("X-Goog-Channel-Token", token)
+	r.Header.Set("X-Goog-Resource-State", state)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestNotificationHandler(t *testing.T) {
+	syncs := 0
+	h := notificationHandler{secret: "s3cret", sync: func(ctx context.Context) error {
+		syncs++
+		return nil
+	}}
+	if got := notify(h, "guess", "exists"); got != http.StatusForbidden {
+		t.Errorf("wrong token: status %d, want %d", got, http.StatusForbidden)
+	}
+	if got := notify(h, "", "exists"); got != http.StatusForbidden {
+		t.Errorf("no token: status %d, want %d", got, http.StatusForbidden)
+	}
+	if got := notify(h, "s3cret", "sync"); got != http.StatusOK {
+		t.Errorf("sync message: status %d, want %d", got, http.StatusOK)
+	}
+	if syncs != 0 {
+		t.Errorf("synced %d times before a change, want none", syncs)
+	}
+	if got := notify(h, "s3cret", "exists"); got != http.StatusOK {
+		t.Errorf("change: status %d, want %d", got, http.StatusOK)
+	}
+	if syncs != 1 {
+		t.Errorf("synced %d times after a change, want once", syncs)
+	}
+}
+
+func TestEventSyncerWritesChanges(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "sync.json")
+	srv := &fakeService{pages: map[string][]*calendar.Events{"primary": syncPages(2, "first")}}
+	var out bytes.Buffer
+	f, err := newFormatter("csv", &out, formatOptions{fields: mustParseFields(t, "id"), noHeader: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &eventSyncer{lister: srv, calendarID: "primary", state: syncState{}, path: path, formatter: f}
+	h := notificationHandler{secret: "s3cret", sync: s.sync}
+	if got := notify(h, "s3cret", "exists"); got != http.StatusOK {
+		t.Fatalf("status %d, want %d", got, http.StatusOK)
+	}
+	srv.pages["primary"] = syncPages(1, "second")
+	if got := notify(h, "s3cret", "exists"); got != http.StatusOK {
+		t.Fatalf("status %d, want %d", got, http.StatusOK)
+	}
+	if got := lines(out.String()); strings.Join(got, " ") != "e1 e2 e1" {
+		t.Errorf("wrote %q, want both events and then the changed one", got)
+	}
+	if q := srv.query("primary"); !q.sync || q.syncToken != "first" {
+		t.Errorf("second sync listed with %+v, want the sync token first", q)
+	}
+	if state, _ := loadSyncState(path); state["primary"] != "second" {
+		t.Errorf("saved state %v, want the token second", state)
+	}
+}
+
+func TestServeRequiresSecret(t *testing.T) {
+	defer useService(&fakeService{})()
+	_, err := runCommand("serve", "--sync-state", "sync.json")
+	if err == nil || !strings.Contains(err.Error(), "--channel-token is required") {
+		t.Errorf("got error %v, want --channel-token required", err)
+	}
+}
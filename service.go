@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"sync"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// EventLister pages through the events of a calendar matching a query,
+// passing each page to fn until fn returns an error or the pages run out.
+type EventLister interface {
+	ListEvents(ctx context.Context, calendarID string, q eventQuery, fn func(*calendar.Events) error) error
+}
+
+// EventInstancer pages through the occurrences of a recurring event between
+// min and max, passing each page to fn like EventLister.
+type EventInstancer interface {
+	ListInstances(ctx context.Context, calendarID, eventID string, min, max time.Time, fn func(*calendar.Events) error) error
+}
+
+// BusyQuerier reports the busy periods of calendars between min and max.
+type BusyQuerier interface {
+	QueryFreeBusy(ctx context.Context, calendarIDs []string, min, max time.Time) (*calendar.FreeBusyResponse, error)
+}
+
+// CalendarLister lists and looks up the calendars in the user's calendar list.
+type CalendarLister interface {
+	ListCalendars(ctx context.Context, fn func(*calendar.CalendarList) error) error
+	GetCalendar(ctx context.Context, calendarID string) (*calendar.CalendarListEntry, error)
+}
+
+// EventGetter looks up a single event by ID.
+type EventGetter interface {
+	GetEvent(ctx context.Context, calendarID, eventID string) (*calendar.Event, error)
+}
+
+// EventInserter adds events to a calendar.
+type EventInserter interface {
+	InsertEvent(ctx context.Context, calendarID string, item *calendar.Event) (*calendar.Event, error)
+}
+
+// EventDeleter removes events from a calendar.
+type EventDeleter interface {
+	DeleteEvent(ctx context.Context, calendarID, eventID string) error
+}
+
+// ColorGetter returns the color palettes of calendars and events.
+type ColorGetter interface {
+	GetColors(ctx context.Context) (*calendar.Colors, error)
+}
+
+// EventWatcher subscribes to and unsubscribes from notifications of changes
+// to the events of a calendar.
+type EventWatcher interface {
+	WatchEvents(ctx context.Context, calendarID string, ch *calendar.Channel) (*calendar.Channel, error)
+	StopChannel(ctx context.Context, ch *calendar.Channel) error
+}
+
+// CalendarService is the part of the Calendar API used by the commands.
+type CalendarService interface {
+	EventLister
+	EventInstancer
+	BusyQuerier
+	CalendarLister
+	EventGetter
+	EventInserter
+	EventDeleter
+	ColorGetter
+	EventWatcher
+}
+
+// apiService adapts a Calendar API service to the interfaces used here.
+type apiService struct {
+	srv   *calendar.Service
+	retry retryPolicy
+}
+
+// Pages through the list results like EventsListCall.Pages, retrying each
+// page request on transient failures.
+func (s apiService) ListEvents(ctx context.Context, calendarID string, q eventQuery, fn func(*calendar.Events) error) error {
+	call := q.call(s.srv, calendarID).Context(ctx)
+	return s.pages(ctx, calendarID, func(token string) (*calendar.Events, error) {
+		if token != "" {
+			call.PageToken(token)
+		}
+		return call.Do()
+	}, fn)
+}
+
+// Pages through the occurrences of a recurring event the same way as
+// ListEvents.
+func (s apiService) ListInstances(ctx context.Context, calendarID, eventID string, min, max time.Time, fn func(*calendar.Events) error) error {
+	call := s.srv.Events.Instances(calendarID, eventID).
+		TimeMin(min.Format(time.RFC3339)).
+		TimeMax(max.Format(time.RFC3339)).
+		MaxResults(maxResults).
+		Context(ctx)
+	return s.pages(ctx, calendarID, func(token string) (*calendar.Events, error) {
+		if token != "" {
+			call.PageToken(token)
+		}
+		return call.Do()
+	}, fn)
+}
+
+// Fetches the pages returned by do for each page token, starting with none,
+// and passes them to fn until the last page.
+func (s apiService) pages(ctx context.Context, calendarID string, do func(token string) (*calendar.Events, error), fn func(*calendar.Events) error) error {
+	var token string
+	for n := 1; ; n++ {
+		var page *calendar.Events
+		start := time.Now()
+		err := s.retry.do(ctx, func() error {
+			var err error
+			page, err = do(token)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		metrics.observeFetch(time.Since(start))
+		debugf("calendar %s page %d: %d items in %v, more pages: %v", calendarID, n, len(page.Items),
+			time.Since(start).Round(time.Millisecond), page.NextPageToken != "")
+		if err := fn(page); err != nil {
+			return err
+		}
+		if page.NextPageToken == "" {
+			return nil
+		}
+		token = page.NextPageToken
+	}
+}
+
+func (s apiService) QueryFreeBusy(ctx context.Context, calendarIDs []string, min, max time.Time) (*calendar.FreeBusyResponse, error) {
+	req := &calendar.FreeBusyRequest{
+		TimeMin: min.Format(time.RFC3339),
+		TimeMax: max.Format(time.RFC3339),
+	}
+	for _, id := range calendarIDs {
+		req.Items = append(req.Items, &calendar.FreeBusyRequestItem{Id: id})
+	}
+	var resp *calendar.FreeBusyResponse
+	err := s.retry.do(ctx, func() error {
+		var err error
+		resp, err = s.srv.Freebusy.Query(req).Context(ctx).Do()
+		return err
+	})
+	return resp, err
+}
+
+func (s apiService) ListCalendars(ctx context.Context, fn func(*calendar.CalendarList) error) error {
+	return s.srv.CalendarList.List().Pages(ctx, fn)
+}
+
+func (s apiService) GetCalendar(ctx context.Context, calendarID string) (*calendar.CalendarListEntry, error) {
+	var entry *calendar.CalendarListEntry
+	err := s.retry.do(ctx, func() error {
+		var err error
+		entry, err = s.srv.CalendarList.Get(calendarID).Context(ctx).Do()
+		return err
+	})
+	return entry, err
+}
+
+func (s apiService) GetEvent(ctx context.Context, calendarID, eventID string) (*calendar.Event, error) {
+	var item *calendar.Event
+	err := s.retry.do(ctx, func() error {
+		var err error
+		item, err = s.srv.Events.Get(calendarID, eventID).Context(ctx).Do()
+		return err
+	})
+	return item, err
+}
+
+// Inserts item without retrying, since a request that failed after reaching
+// the API may still have created the event.
+func (s apiService) InsertEvent(ctx context.Context, calendarID string, item *calendar.Event) (*calendar.Event, error) {
+	return s.srv.Events.Insert(calendarID, item).Context(ctx).Do()
+}
+
+// Deletes an event, retrying transient failures. A retry after a request that
+// did succeed fails with a 410, which callers treat as already deleted.
+func (s apiService) DeleteEvent(ctx context.Context, calendarID, eventID string) error {
+	return s.retry.do(ctx, func() error {
+		return s.srv.Events.Delete(calendarID, eventID).Context(ctx).Do()
+	})
+}
+
+func (s apiService) GetColors(ctx context.Context) (*calendar.Colors, error) {
+	var colors *calendar.Colors
+	err := s.retry.do(ctx, func() error {
+		var err error
+		colors, err = s.srv.Colors.Get().Context(ctx).Do()
+		return err
+	})
+	return colors, err
+}
+
+// Creates the channel without retrying, since a request that failed after
+// reaching the API may still have created it, and a repeat with the same ID
+// is rejected.
+func (s apiService) WatchEvents(ctx context.Context, calendarID string, ch *calendar.Channel) (*calendar.Channel, error) {
+	return s.srv.Events.Watch(calendarID, ch).Context(ctx).Do()
+}
+
+func (s apiService) StopChannel(ctx context.Context, ch *calendar.Channel) error {
+	return s.retry.do(ctx, func() error {
+		return s.srv.Channels.Stop(ch).Context(ctx).Do()
+	})
+}
+
+// Returns the email address of the authorized user, which is the ID of their
+// primary calendar.
+func primaryEmail(ctx context.Context, c CalendarLister) (string, error) {
+	entry, err := c.GetCalendar(ctx, "primary")
+	if err != nil {
+		return "", err
+	}
+	return entry.Id, nil
+}
+
+// Authorizes with the parsed auth flags and returns the Calendar API. Tests
+// replace it with fakes serving canned responses.
+var newCalendarService = func(ctx context.Context, auth *authFlags, fs *flag.FlagSet, stderr io.Writer, retry retryPolicy) (CalendarService, error) {
+	srv, err := auth.service(ctx, fs, stderr)
+	if err != nil {
+		return nil, authError{err}
+	}
+	return apiService{srv, retry}, nil
+}
+
+// Returns the Calendar API authorized with the auth flags. When the API
+// rejects a cached OAuth token, the token is removed and authorized again
+// once, and the rejected request repeated.
+func connect(ctx context.Context, auth *authFlags, fs *flag.FlagSet, stderr io.Writer, retry retryPolicy) (CalendarService, error) {
+	srv, err := newCalendarService(ctx, auth, fs, stderr, retry)
+	if err != nil || !auth.renewable() {
+		return srv, err
+	}
+	return &renewingService{srv: srv, renew: func() (CalendarService, error) {
+		if err := auth.removeToken(); err != nil {
+			return nil, err
+		}
+		infof("The API rejected the cached token, authorizing again")
+		return newCalendarService(ctx, auth, fs, stderr, retry)
+	}}, nil
+}
+
+// renewingService repeats requests the API rejected the token of with the
+// service returned by renew, which is only called once.
+type renewingService struct {
+	renew func() (CalendarService, error)
+
+	mu      sync.Mutex
+	srv     CalendarService
+	renewed bool
+}
+
+// Calls call with the service, and again with the renewed service when the
+// API rejected the token and *passed, if given, is still false. Paging calls
+// set it once a page was passed on, which must not be repeated.
+func (s *renewingService) do(call func(CalendarService) error, passed *bool) error {
+	s.mu.Lock()
+	srv, renewed := s.srv, s.renewed
+	s.mu.Unlock()
+	err := call(srv)
+	if !isUnauthorized(err) || renewed || (passed != nil && *passed) {
+		return err
+	}
+	srv, renewErr := s.renewedService()
+	if renewErr != nil {
+		infof("Unable to authorize again: %v", renewErr)
+		return err
+	}
+	return call(srv)
+}
+
+// Returns the service authorized again, renewing it for the first request
+// the API rejected and reusing it for the others.
+func (s *renewingService) renewedService() (CalendarService, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.renewed {
+		s.renewed = true
+		srv, err := s.renew()
+		if err != nil {
+			return nil, err
+		}
+		s.srv = srv
+	}
+	return s.srv, nil
+}
+
+func (s *renewingService) ListEvents(ctx context.Context, calendarID string, q eventQuery, fn func(*calendar.Events) error) error {
+	var passed bool
+	return s.do(func(srv CalendarService) error {
+		return srv.ListEvents(ctx, calendarID, q, func(page *calendar.Events) error {
+			passed = true
+			return fn(page)
+		})
+	}, &passed)
+}
+
+func (s *renewingService) ListInstances(ctx context.Context, calendarID, eventID string, min, max time.Time, fn func(*calendar.Events) error) error {
+	var passed bool
+	return s.do(func(srv CalendarService) error {
+		return srv.ListInstances(ctx, calendarID, eventID, min, max, func(page *calendar.Events) error {
+			passed = true
+			return fn(page)
+		})
+	}, &passed)
+}
+
+func (s *renewingService) QueryFreeBusy(ctx context.Context, calendarIDs []string, min, max time.Time) (*calendar.FreeBusyResponse, error) {
+	var resp *calendar.FreeBusyResponse
+	err := s.do(func(srv CalendarService) error {
+		var err error
+		resp, err = srv.QueryFreeBusy(ctx, calendarIDs, min, max)
+		return err
+	}, nil)
+	return resp, err
+}
+
+func (s *renewingService) ListCalendars(ctx context.Context, fn func(*calendar.CalendarList) error) error {
+	var passed bool
+	return s.do(func(srv CalendarService) error {
+		return srv.ListCalendars(ctx, func(page *calendar.CalendarList) error {
+			passed = true
+			return fn(page)
+		})
+	}, &passed)
+}
+
+func (s *renewingService) GetCalendar(ctx context.Context, calendarID string) (*calendar.CalendarListEntry, error) {
+	var entry *calendar.CalendarListEntry
+	err := s.do(func(srv CalendarService) error {
+		var err error
+		entry, err = srv.GetCalendar(ctx, calendarID)
+		return err
+	}, nil)
+	return entry, err
+}
+
+func (s *renewingService) GetEvent(ctx context.Context, calendarID, eventID string) (*calendar.Event, error) {
+	var item *calendar.Event
+	err := s.do(func(srv CalendarService) error {
+		var err error
+		item, err = srv.GetEvent(ctx, calendarID, eventID)
+		return err
+	}, nil)
+	return item, err
+}
+
+func (s *renewingService) InsertEvent(ctx context.Context, calendarID string, item *calendar.Event) (*calendar.Event, error) {
+	var inserted *calendar.Event
+	err := s.do(func(srv CalendarService) error {
+		var err error
+		inserted, err = srv.InsertEvent(ctx, calendarID, item)
+		return err
+	}, nil)
+	return inserted, err
+}
+
+func (s *renewingService) DeleteEvent(ctx context.Context, calendarID, eventID string) error {
+	return s.do(func(srv CalendarService) error {
+		return srv.DeleteEvent(ctx, calendarID, eventID)
+	}, nil)
+}
+
+func (s *renewingService) GetColors(ctx context.Context) (*calendar.Colors, error) {
+	var colors *calendar.Colors
+	err := s.do(func(srv CalendarService) error {
+		var err error
+		colors, err = srv.GetColors(ctx)
+		return err
+	}, nil)
+	return colors, err
+}
+
+func (s *renewingService) WatchEvents(ctx context.Context, calendarID string, ch *calendar.Channel) (*calendar.Channel, error) {
+	var created *calendar.Channel
+	err := s.do(func(srv CalendarService) error {
+		var err error
+		created, err = srv.WatchEvents(ctx, calendarID, ch)
+		return err
+	}, nil)
+	return created, err
+}
+
+func (s *renewingService) StopChannel(ctx context.Context, ch *calendar.Channel) error {
+	return s.do(func(srv CalendarService) error {
+		return srv.StopChannel(ctx, ch)
+	}, nil)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// fakeService serves canned pages of events per calendar and records the
+// queries it is given. Methods a test does not set up panic through the nil
+// embedded CalendarService.
+type fakeService struct {
+	CalendarService
+	// pages holds the pages of events of each calendar.
+	pages map[string][]*calendar.Events
+	// errs fails listing the events of a calendar.
+	errs map[string]error
+	// calendars is the user's calendar list, with the primary calendar
+	// marked as such.
+	calendars []*calendar.CalendarListEntry
+	// calendarGets counts the calendars looked up.
+	calendarGets int
+
+	mu      sync.Mutex
+	queries map[string]eventQuery
+	fetched int
+	// inserted holds the events created, by calendar ID.
+	inserted map[string][]*calendar.Event
+	// colors is the color palette, and colorFetches counts its requests.
+	colors       *calendar.Colors
+	colorFetches int
+	// watched holds the channels created, by calendar ID, and stopped the
+	// channels stopped.
+	watched map[string][]*calendar.Channel
+	stopped []*calendar.Channel
+	// instances holds the pages of occurrences of each recurring event, and
+	// instanceWindows the window each was last listed over.
+	instances       map[string][]*calendar.Events
+	instanceWindows map[string][2]time.Time
+	// busy holds the free/busy information of each calendar, and
+	// busyWindow the window it was last queried over.
+	busy       map[string]calendar.FreeBusyCalendar
+	busyWindow [2]time.Time
+}
+
+func (s *fakeService) ListEvents(ctx context.Context, calendarID string, q eventQuery, fn func(*calendar.Events) error) error {
+	s.mu.Lock()
+	if s.queries == nil {
+		s.queries = map[string]eventQuery{}
+	}
+	s.queries[calendarID] = q
+	s.mu.Unlock()
+	if err := s.errs[calendarID]; err != nil {
+		return err
+	}
+	pages, ok := s.pages[calendarID]
+	if !ok {
+		return &googleapi.Error{Code: http.StatusNotFound}
+	}
+	for _, page := range pages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.fetched++
+		s.mu.Unlock()
+		if err := fn(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeService) ListInstances(ctx context.Context, calendarID, eventID string, min, max time.Time, fn func(*calendar.Events) error) error {
+	if s.instanceWindows == nil {
+		s.instanceWindows = map[string][2]time.Time{}
+	}
+	s.instanceWindows[eventID] = [2]time.Time{min, max}
+	pages, ok := s.instances[eventID]
+	if !ok {
+		return &googleapi.Error{Code: http.StatusNotFound}
+	}
+	for _, page := range pages {
+		if err := fn(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the free/busy information of the calendars found in busy, leaving
+// the others out of the response as the API does for unknown IDs.
+func (s *fakeService) QueryFreeBusy(ctx context.Context, calendarIDs []string, min, max time.Time) (*calendar.FreeBusyResponse, error) {
+	s.busyWindow = [2]time.Time{min, max}
+	resp := &calendar.FreeBusyResponse{Calendars: map[string]calendar.FreeBusyCalendar{}}
+	for _, id := range calendarIDs {
+		if cal, ok := s.busy[id]; ok {
+			resp.Calendars[id] = cal
+		}
+	}
+	return resp, nil
+}
+
+// Returns the event with the ID among the pages of calendarID, or the error
+// listing them fails with.
+func (s *fakeService) GetEvent(ctx context.Context, calendarID, eventID string) (*calendar.Event, error) {
+	if err := s.errs[calendarID]; err != nil {
+		return nil, err
+	}
+	for _, page := range s.pages[calendarID] {
+		for _, item := range page.Items {
+			if item.Id == eventID {
+				return item, nil
+			}
+		}
+	}
+	return nil, &googleapi.Error{Code: http.StatusNotFound}
+}
+
+func (s *fakeService) ListCalendars(ctx context.Context, fn func(*calendar.CalendarList) error) error {
+	return fn(&calendar.CalendarList{Items: s.calendars})
+}
+
+func (s *fakeService) GetCalendar(ctx context.Context, calendarID string) (*calendar.CalendarListEntry, error) {
+	s.calendarGets++
+	for _, entry := range s.calendars {
+		if entry.Id == calendarID || calendarID == "primary" && entry.Primary {
+			return entry, nil
+		}
+	}
+	return nil, &googleapi.Error{Code: http.StatusNotFound}
+}
+
+func (s *fakeService) InsertEvent(ctx context.Context, calendarID string, item *calendar.Event) (*calendar.Event, error) {
+	if s.inserted == nil {
+		s.inserted = map[string][]*calendar.Event{}
+	}
+	s.inserted[calendarID] = append(s.inserted[calendarID], item)
+	created := *item
+	created.Id = fmt.Sprintf("new%d", len(s.inserted[calendarID]))
+	created.HtmlLink = "https://calendar.google.com/event?eid=" + created.Id
+	return &created, nil
+}
+
+func (s *fakeService) GetColors(ctx context.Context) (*calendar.Colors, error) {
+	s.colorFetches++
+	return s.colors, nil
+}
+
+func (s *fakeService) WatchEvents(ctx context.Context, calendarID string, ch *calendar.Channel) (*calendar.Channel, error) {
+	if s.watched == nil {
+		s.watched = map[string][]*calendar.Channel{}
+	}
+	s.watched[calendarID] = append(s.watched[calendarID], ch)
+	created := *ch
+	created.ResourceId = "resource-" + calendarID
+	created.Expiration = time.Date(2024, 1, 22, 0, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	return &created, nil
+}
+
+func (s *fakeService) StopChannel(ctx context.Context, ch *calendar.Channel) error {
+	s.stopped = append(s.stopped, ch)
+	return nil
+}
+
+// Returns the query the events of calendarID were last listed with.
+func (s *fakeService) query(calendarID string) eventQuery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries[calendarID]
+}
+
+// Makes commands use srv instead of the Calendar API, until the returned
+// function restores it.
+func useService(srv CalendarService) func() {
+	saved := newCalendarService
+	newCalendarService = func(ctx context.Context, auth *authFlags, fs *flag.FlagSet, stderr io.Writer, retry retryPolicy) (CalendarService, error) {
+		return srv, nil
+	}
+	return func() { newCalendarService = saved }
+}
+
+// Runs the command line args and returns what it wrote to stdout.
+func runCommand(args ...string) (string, error) {
+	var stdout bytes.Buffer
+	err := run(context.Background(), args, &stdout, ioutil.Discard)
+	return stdout.String(), err
+}
+
+// Returns a timed event starting at start, an RFC3339 time, and lasting d.
+func timedEvent(id, start string, d time.Duration) *calendar.Event {
+	t, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		panic(err)
+	}
+	return &calendar.Event{
+		Id:      id,
+		Summary: "Event " + id,
+		Status:  "confirmed",
+		Start:   &calendar.EventDateTime{DateTime: start},
+		End:     &calendar.EventDateTime{DateTime: t.Add(d).Format(time.RFC3339)},
+	}
+}
+
+// Returns an all-day event from the date start on for days days.
+func allDayEvent(id, start string, days int) *calendar.Event {
+	t, err := time.Parse("2006-01-02", start)
+	if err != nil {
+		panic(err)
+	}
+	return &calendar.Event{
+		Id:      id,
+		Summary: "Event " + id,
+		Status:  "confirmed",
+		Start:   &calendar.EventDateTime{Date: start},
+		End:     &calendar.EventDateTime{Date: t.AddDate(0, 0, days).Format("2006-01-02")},
+	}
+}
+
+// Returns n hour-long events an hour apart from 2024-01-01 on, split into
+// pages of perPage events.
+func eventPages(n, perPage int) []*calendar.Events {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var pages []*calendar.Events
+	for i := 0; i < n; i++ {
+		if i%perPage == 0 {
+			if len(pages) > 0 {
+				pages[len(pages)-1].NextPageToken = fmt.Sprintf("page%d", len(pages)+1)
+			}
+			pages = append(pages, &calendar.Events{})
+		}
+		page := pages[len(pages)-1]
+		item := timedEvent(fmt.Sprintf("e%d", i+1), start.Add(time.Duration(i)*time.Hour).Format(time.RFC3339), time.Hour)
+		page.Items = append(page.Items, item)
+	}
+	return pages
+}
+
+// Creates a temporary directory and returns its path and a function
+// removing it.
+func tempDir(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "calendar-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// Passes pages to fn like EventsListCall.Pages, stopping at the first error,
+// and returns the number of pages passed.
+func fakePager(pages []*calendar.Events, fn func(*calendar.Events) error) (int, error) {
+	for i, page := range pages {
+		if err := fn(page); err != nil {
+			return i + 1, err
+		}
+	}
+	return len(pages), nil
+}
+
+// Passes pages to the callback of c writing CSV with opts, and returns the
+// output, the number of pages passed and the error that stopped paging.
+func collectCSV(c *EventCollector, opts formatOptions, pages []*calendar.Events) (string, int, error) {
+	var out bytes.Buffer
+	f, err := newFormatter("csv", &out, opts)
+	if err != nil {
+		return "", 0, err
+	}
+	fetched, err := fakePager(pages, c.WriteCallback(context.Background(), f))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return out.String(), fetched, err
+}
+
+// Splits output into its non-empty lines.
+func lines(s string) []string {
+	var l []string
+	for _, line := range strings.Split(s, "\n") {
+		if line != "" {
+			l = append(l, line)
+		}
+	}
+	return l
+}
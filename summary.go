@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// summaryFormatter accumulates statistics over the events instead of writing
+// them, and prints a digest when closed.
+type summaryFormatter struct {
+	w      io.Writer
+	events int
+	allDay int
+	// noEnd counts timed events without an end, which add no duration.
+	noEnd int
+	// free counts timed events shown as free, which add no duration.
+	free  int
+	total time.Duration
+	// days holds the scheduled time of timed events by start date.
+	days map[string]time.Duration
+	// merge, when set, holds the busy intervals until Close, which joins
+	// those overlapping or at most mergeGap apart, counting the gaps as busy,
+	// before summing them.
+	merge     bool
+	mergeGap  time.Duration
+	intervals []interval
+}
+
+func newSummaryFormatter(w io.Writer) *summaryFormatter {
+	return &summaryFormatter{w: w, days: map[string]time.Duration{}}
+}
+
+func (f *summaryFormatter) WriteEvent(item *Event) error {
+	f.events++
+	if isAllDay(item) {
+		f.allDay++
+		return nil
+	}
+	start, end := eventStart(item), eventEnd(item)
+	if start.IsZero() {
+		return nil
+	}
+	if isFree(item) {
+		f.free++
+		return nil
+	}
+	var d time.Duration
+	if end.IsZero() {
+		f.noEnd++
+	} else if end.After(start) {
+		d = end.Sub(start)
+	}
+	if f.merge {
+		if d > 0 {
+			f.intervals = append(f.intervals, interval{start, end})
+		}
+		return nil
+	}
+	f.total += d
+	f.days[start.Format("2006-01-02")] += d
+	return nil
+}
+
+func (f *summaryFormatter) Flush() error {
+	return nil
+}
+
+func (f *summaryFormatter) Close() error {
+	for _, b := range mergeIntervals(f.intervals, f.mergeGap) {
+		f.total += b.end.Sub(b.start)
+		f.days[b.start.Format("2006-01-02")] += b.end.Sub(b.start)
+	}
+	busiest := ""
+	for day, d := range f.days {
+		if busiest == "" || d > f.days[busiest] || d == f.days[busiest] && day < busiest {
+			busiest = day
+		}
+	}
+	fmt.Fprintf(f.w, "events: %d\n", f.events)
+	fmt.Fprintf(f.w, "all-day events: %d\n", f.allDay)
+	fmt.Fprintf(f.w, "total duration: %v\n", f.total)
+	if f.noEnd > 0 {
+		fmt.Fprintf(f.w, "events without an end time (counted as zero): %d\n", f.noEnd)
+	}
+	if f.free > 0 {
+		fmt.Fprintf(f.w, "events shown as free (counted as zero): %d\n", f.free)
+	}
+	if busiest != "" {
+		_, err := fmt.Fprintf(f.w, "busiest day: %s (%v)\n", busiest, f.days[busiest])
+		return err
+	}
+	return nil
+}
+
+// groupRange describes what a grouping reports on: the query window in the
+// output location and the calendars queried.
+type groupRange struct {
+	start     time.Time
+	end       time.Time
+	loc       *time.Location
+	calendars []string
+}
+
+// A grouping buckets events by a key, such as the day they start on.
+type grouping struct {
+	// key returns the bucket of an event starting at start.
+	key func(item *Event, start time.Time) string
+	// keys returns every bucket in r, so that empty buckets are reported.
+	keys func(r groupRange) []string
+}
+
+// Groupings accepted by --group-by.
+var groupings = map[string]grouping{
+	"day": {
+		key: func(item *Event, start time.Time) string { return start.Format("2006-01-02") },
+		keys: func(r groupRange) []string {
+			var keys []string
+			for d := startOfDay(r.start.In(r.loc)); d.Before(r.end); d = d.AddDate(0, 0, 1) {
+				keys = append(keys, d.Format("2006-01-02"))
+			}
+			return keys
+		},
+	},
+	"week": {
+		key: func(item *Event, start time.Time) string { return isoWeek(start) },
+		keys: func(r groupRange) []string {
+			var keys []string
+			for d := startOfWeek(r.start.In(r.loc)); d.Before(r.end); d = d.AddDate(0, 0, 7) {
+				keys = append(keys, isoWeek(d))
+			}
+			return keys
+		},
+	},
+	"calendar": {
+		key:  func(item *Event, start time.Time) string { return item.Calendar },
+		keys: func(r groupRange) []string { return r.calendars },
+	},
+}
+
+// Formats the ISO 8601 week of t, like 2024-W03.
+func isoWeek(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+type groupTotals struct {
+	events int
+	busy   time.Duration
+}
+
+// groupFormatter counts events and scheduled time per bucket of a grouping
+// and writes one row per bucket when closed.
+type groupFormatter struct {
+	w        io.Writer
+	name     string
+	grouping grouping
+	r        groupRange
+	format   string
+	noHeader bool
+	totals   map[string]*groupTotals
+}
+
+// Reports an error unless name is a known grouping and format can be used
+// to write it.
+func checkGrouping(name, format string) error {
+	if _, ok := groupings[name]; !ok {
+		return fmt.Errorf("unknown grouping %q, expected day, week or calendar", name)
+	}
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("--group-by writes csv or json, not %s", format)
+	}
+	return nil
+}
+
+// Returns a formatter grouping events by the named grouping, written as csv
+// or json.
+func newGroupFormatter(w io.Writer, name string, r groupRange, format string, opts formatOptions) (*groupFormatter, error) {
+	if err := checkGrouping(name, format); err != nil {
+		return nil, err
+	}
+	if r.loc == nil {
+		r.loc = time.Local
+	}
+	return &groupFormatter{w: w, name: name, grouping: groupings[name], r: r, format: format,
+		noHeader: opts.noHeader, totals: map[string]*groupTotals{}}, nil
+}
+
+func (f *groupFormatter) WriteEvent(item *Event) error {
+	start, end, allDay, _ := eventInterval(item.Event)
+	// Events without a start fall in no day or week.
+	if start.IsZero() && f.name != "calendar" {
+		return nil
+	}
+	if !allDay {
+		start = start.In(f.r.loc)
+	}
+	key := f.grouping.key(item, start)
+	t := f.totals[key]
+	if t == nil {
+		t = &groupTotals{}
+		f.totals[key] = t
+	}
+	t.events++
+	if !allDay && !isFree(item) && end.After(start) {
+		t.busy += end.Sub(start)
+	}
+	return nil
+}
+
+func (f *groupFormatter) Flush() error {
+	return nil
+}
+
+func (f *groupFormatter) Close() error {
+	seen := map[string]bool{}
+	var keys []string
+	if !f.r.start.IsZero() {
+		keys = f.grouping.keys(f.r)
+	}
+	for _, k := range keys {
+		seen[k] = true
+	}
+	for k := range f.totals {
+		if !seen[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	if f.format == "json" {
+		return f.writeJSON(keys)
+	}
+	w := csv.NewWriter(f.w)
+	if !f.noHeader {
+		if err := w.Write([]string{f.name, "events", "hours"}); err != nil {
+			return err
+		}
+	}
+	for _, k := range keys {
+		t := f.total(k)
+		if err := w.Write([]string{k, strconv.Itoa(t.events), formatHours(t.busy)}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Writes the buckets as a JSON array with one object per line, like the
+// json format.
+func (f *groupFormatter) writeJSON(keys []string) error {
+	name, err := json.Marshal(f.name)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f.w)
+	bw.WriteString("[")
+	for i, k := range keys {
+		if i > 0 {
+			bw.WriteString(",")
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+		t := f.total(k)
+		fmt.Fprintf(bw, "\n{%s:%s,\"events\":%d,\"hours\":%s}", name, key, t.events, formatHours(t.busy))
+	}
+	if len(keys) > 0 {
+		bw.WriteString("\n")
+	}
+	bw.WriteString("]\n")
+	return bw.Flush()
+}
+
+func (f *groupFormatter) total(key string) groupTotals {
+	if t := f.totals[key]; t != nil {
+		return *t
+	}
+	return groupTotals{}
+}
+
+// Formats d in hours with two decimals.
+func formatHours(d time.Duration) string {
+	return strconv.FormatFloat(d.Hours(), 'f', 2, 64)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+func TestMergeAdjacent(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		events []*calendar.Event
+		gap    time.Duration
+		total  string
+	}{
+		{"overlapping", []*calendar.Event{
+			timedEvent("a", "2024-01-15T09:00:00Z", time.Hour),
+			timedEvent("b", "2024-01-15T09:30:00Z", time.Hour),
+			timedEvent("c", "2024-01-15T09:45:00Z", 15*time.Minute),
+		}, 0, "1h30m0s"},
+		{"back to back", []*calendar.Event{
+			timedEvent("a", "2024-01-15T09:00:00Z", time.Hour),
+			timedEvent("b", "2024-01-15T10:00:00Z", time.Hour),
+		}, 0, "2h0m0s"},
+		{"adjacent within gap", []*calendar.Event{
+			timedEvent("a", "2024-01-15T09:00:00Z", time.Hour),
+			timedEvent("b", "2024-01-15T10:05:00Z", 55*time.Minute),
+		}, 5 * time.Minute, "2h0m0s"},
+		{"separate", []*calendar.Event{
+			timedEvent("a", "2024-01-15T09:00:00Z", time.Hour),
+			timedEvent("b", "2024-01-15T10:30:00Z", 30*time.Minute),
+		}, 5 * time.Minute, "1h30m0s"},
+	} {
+		var out bytes.Buffer
+		f := newSummaryFormatter(&out)
+		f.merge, f.mergeGap = true, c.gap
+		for _, item := range c.events {
+			if err := f.WriteEvent(&Event{Event: item}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"total duration: " + c.total + "\n", "busiest day: 2024-01-15 (" + c.total + ")\n"} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("%s: wrote\n%s\nwant it to contain %q", c.name, out.String(), want)
+			}
+		}
+	}
+
+	srv := serviceWith(timedEvent("a", "2024-01-15T09:00:00Z", time.Hour), timedEvent("b", "2024-01-15T09:30:00Z", time.Hour))
+	defer useService(srv)()
+	out, err := runCommand("--start", "2024-01-01", "--end", "2024-01-31", "--summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "total duration: 2h0m0s\n") {
+		t.Errorf("--summary wrote:\n%s\nwant overlaps counted twice without --merge-adjacent", out)
+	}
+	out, err = runCommand("--start", "2024-01-01", "--end", "2024-01-31", "--summary", "--merge-adjacent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "total duration: 1h30m0s\n") {
+		t.Errorf("--summary --merge-adjacent wrote:\n%s\nwant the overlap counted once", out)
+	}
+	if _, err := runCommand("--start", "2024-01-01", "--end", "2024-01-31", "--merge-gap", "5m", "--summary"); err == nil {
+		t.Error("--merge-gap without --merge-adjacent succeeded")
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	inCalendar := func(id string, item *calendar.Event) *Event { return &Event{Event: item, Calendar: id} }
+	for _, c := range []struct {
+		name   string
+		r      groupRange
+		events []*Event
+		csv    string
+		json   string
+	}{
+		{"day", groupRange{start: day(15), end: day(18)}, []*Event{
+			inCalendar("mine", timedEvent("a", "2024-01-15T09:00:00Z", time.Hour)),
+			inCalendar("mine", timedEvent("b", "2024-01-15T13:00:00Z", 30*time.Minute)),
+			inCalendar("mine", timedEvent("c", "2024-01-17T10:00:00Z", 2*time.Hour)),
+		},
+			// The 16th has no events but is in the window.
+			"day,events,hours\n2024-01-15,2,1.50\n2024-01-16,0,0.00\n2024-01-17,1,2.00\n",
+			"[\n" +
+				`{"day":"2024-01-15","events":2,"hours":1.50},` + "\n" +
+				`{"day":"2024-01-16","events":0,"hours":0.00},` + "\n" +
+				`{"day":"2024-01-17","events":1,"hours":2.00}` + "\n]\n",
+		},
+		{"week", groupRange{start: day(8), end: day(29)}, []*Event{
+			inCalendar("mine", timedEvent("a", "2024-01-10T09:00:00Z", time.Hour)),
+			inCalendar("mine", timedEvent("b", "2024-01-23T09:00:00Z", 3*time.Hour)),
+			inCalendar("mine", timedEvent("c", "2024-01-28T09:00:00Z", time.Hour)),
+		},
+			"week,events,hours\n2024-W02,1,1.00\n2024-W03,0,0.00\n2024-W04,2,4.00\n",
+			"[\n" +
+				`{"week":"2024-W02","events":1,"hours":1.00},` + "\n" +
+				`{"week":"2024-W03","events":0,"hours":0.00},` + "\n" +
+				`{"week":"2024-W04","events":2,"hours":4.00}` + "\n]\n",
+		},
+		{"calendar", groupRange{start: day(15), end: day(18), calendars: []string{"mine", "team", "holidays"}}, []*Event{
+			inCalendar("mine", timedEvent("a", "2024-01-15T09:00:00Z", time.Hour)),
+			inCalendar("team", timedEvent("b", "2024-01-15T09:00:00Z", 90*time.Minute)),
+			inCalendar("mine", timedEvent("c", "2024-01-16T09:00:00Z", time.Hour)),
+		},
+			"calendar,events,hours\nholidays,0,0.00\nmine,2,2.00\nteam,1,1.50\n",
+			"[\n" +
+				`{"calendar":"holidays","events":0,"hours":0.00},` + "\n" +
+				`{"calendar":"mine","events":2,"hours":2.00},` + "\n" +
+				`{"calendar":"team","events":1,"hours":1.50}` + "\n]\n",
+		},
+	} {
+		c.r.loc = time.UTC
+		for _, format := range []string{"csv", "json"} {
+			var out bytes.Buffer
+			f, err := newGroupFormatter(&out, c.name, c.r, format, formatOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, item := range c.events {
+				if err := f.WriteEvent(item); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			want := c.csv
+			if format == "json" {
+				want = c.json
+			}
+			if out.String() != want {
+				t.Errorf("--group-by %s --format %s wrote\n%s\nwant\n%s", c.name, format, out.String(), want)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Flags that set request parameters the API rejects alongside a sync token.
+var syncIncompatibleFlags = []string{"start", "end", "from", "to", "window", "since", "until", "query", "order-by", "updated-after", "property", "shared-property"}
+
+// syncState maps calendar IDs to the sync token returned by their last sync.
+type syncState map[string]string
+
+// Loads the sync state saved at path. A missing file is an empty state.
+func loadSyncState(path string) (syncState, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return syncState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := syncState{}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("unable to parse sync state %s: %v", path, err)
+	}
+	return state, nil
+}
+
+// Saves the sync state to path.
+func (s syncState) save(path string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+// Fetches the events of calendarID like fetchEvents. When the API has expired
+// the sync token of q, every event is fetched again as in a first sync.
+func fetchSync(ctx context.Context, lister EventLister, calendarID string, q eventQuery, fn func(*calendar.Events) error) error {
+	err := fetchEvents(ctx, lister, calendarID, q, fn)
+	if isGone(err) && q.syncToken != "" {
+		infof("Sync token for %s has expired, doing a full sync", calendarID)
+		metrics.syncReset()
+		q.syncToken = ""
+		err = fetchEvents(ctx, lister, calendarID, q, fn)
+	}
+	return err
+}
+
+// Reports whether err is the 410 the API returns for an expired sync token.
+func isGone(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == http.StatusGone
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// goneService fails listing events with a 410 when given an expired sync
+// token, as the API does, and records the sync tokens it is given.
+type goneService struct {
+	*fakeService
+	expired string
+	tokens  []string
+}
+
+func (s *goneService) ListEvents(ctx context.Context, calendarID string, q eventQuery, fn func(*calendar.Events) error) error {
+	s.tokens = append(s.tokens, q.syncToken)
+	if q.syncToken == s.expired {
+		return &googleapi.Error{Code: http.StatusGone}
+	}
+	return s.fakeService.ListEvents(ctx, calendarID, q, fn)
+}
+
+// Returns pages of n events whose last page carries the sync token next.
+func syncPages(n int, next string) []*calendar.Events {
+	pages := eventPages(n, 10)
+	pages[len(pages)-1].NextSyncToken = next
+	return pages
+}
+
+func TestSyncStateSavedAndUsed(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "sync.json")
+	srv := &fakeService{pages: map[string][]*calendar.Events{"primary": syncPages(2, "first")}}
+	defer useService(srv)()
+	out, err := runCommand("--sync-state", path, "--fields", "summary", "--no-header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lines(out); len(got) != 2 {
+		t.Errorf("full sync wrote %q, want both events", got)
+	}
+	if q := srv.query("primary"); !q.sync || q.syncToken != "" {
+		t.Errorf("first run listed with %+v, want a full sync", q)
+	}
+	state, err := loadSyncState(path)
+	if err != nil || state["primary"] != "first" {
+		t.Fatalf("saved state %v, %v, want the token first", state, err)
+	}
+
+	srv.pages["primary"] = syncPages(1, "second")
+	if _, err := runCommand("--sync-state", path); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.query("primary").syncToken; got != "first" {
+		t.Errorf("second run listed with sync token %q, want first", got)
+	}
+	if state, _ := loadSyncState(path); state["primary"] != "second" {
+		t.Errorf("saved state %v, want the token second", state)
+	}
+}
+
+func TestExpiredSyncTokenFullSync(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "sync.json")
+	if err := (syncState{"primary": "stale"}).save(path); err != nil {
+		t.Fatal(err)
+	}
+	srv := &goneService{fakeService: &fakeService{pages: map[string][]*calendar.Events{"primary": syncPages(3, "fresh")}}, expired: "stale"}
+	defer useService(srv)()
+	out, err := runCommand("--sync-state", path, "--fields", "summary", "--no-header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.tokens) != 2 || srv.tokens[0] != "stale" || srv.tokens[1] != "" {
+		t.Errorf("listed with sync tokens %q, want the saved one and then a full sync", srv.tokens)
+	}
+	if got := lines(out); len(got) != 3 {
+		t.Errorf("wrote %q, want all three events", got)
+	}
+	if state, _ := loadSyncState(path); state["primary"] != "fresh" {
+		t.Errorf("saved state %v, want the token fresh", state)
+	}
+}
+
+func TestSyncStateIncompatibleFlags(t *testing.T) {
+	defer useService(&fakeService{})()
+	for _, args := range [][]string{
+		{"--start", "2024-01-01"},
+		{"--query", "standup"},
+		{"--calendar", "a,b"},
+	} {
+		if _, err := runCommand(append([]string{"--sync-state", "sync.json"}, args...)...); err == nil {
+			t.Errorf("%v: --sync-state was accepted", args)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateEvent is the data a --template is executed against for each event.
+type templateEvent struct {
+	ID          string
+	Summary     string
+	Description string
+	Location    string
+	Status      string
+	Calendar    string
+	HTMLLink    string
+	MeetLink    string
+	Organizer   string
+	Attendees   []string
+	// Start and End are zero when the event has none. All-day events start
+	// and end at local midnight.
+	Start  time.Time
+	End    time.Time
+	AllDay bool
+	// Event is the event as returned by the API.
+	Event *Event
+}
+
+func newTemplateEvent(item *Event) templateEvent {
+	t := templateEvent{
+		ID:          item.Id,
+		Summary:     item.Summary,
+		Description: item.Description,
+		Location:    item.Location,
+		Status:      item.Status,
+		Calendar:    item.Calendar,
+		HTMLLink:    item.HtmlLink,
+		MeetLink:    meetLink(item),
+		Start:       eventStart(item),
+		End:         eventEnd(item),
+		AllDay:      isAllDay(item),
+		Event:       item,
+	}
+	if item.Organizer != nil {
+		t.Organizer = item.Organizer.Email
+	}
+	for _, a := range item.Attendees {
+		t.Attendees = append(t.Attendees, a.Email)
+	}
+	return t
+}
+
+// Functions available to templates.
+var templateFuncs = template.FuncMap{
+	// date formats t with a Go layout like "2006-01-02 15:04".
+	"date": func(layout string, t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(layout)
+	},
+	// duration returns the time from start to end.
+	"duration": func(start, end time.Time) time.Duration {
+		if start.IsZero() || end.IsZero() {
+			return 0
+		}
+		return end.Sub(start)
+	},
+	"join": strings.Join,
+}
+
+// Expands the \t, \n and \\ escapes that a shell passes through literally.
+var templateUnescaper = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n")
+
+// Parses the template given with --template, or read from the file given
+// with --template-file.
+func parseTemplate(text, path string) (*template.Template, error) {
+	switch {
+	case text != "" && path != "":
+		return nil, fmt.Errorf("--template cannot be combined with --template-file")
+	case path != "":
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read template file: %v", err)
+		}
+		text = string(b)
+	case text != "":
+		text = templateUnescaper.Replace(text)
+	default:
+		return nil, fmt.Errorf("--format template requires --template or --template-file")
+	}
+	t, err := template.New("template").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %v", err)
+	}
+	return t, nil
+}
+
+// templateFormatter writes each event by executing a template, followed by
+// a newline unless the template ends with one.
+type templateFormatter struct {
+	w       *bufio.Writer
+	t       *template.Template
+	newline bool
+}
+
+func newTemplateFormatter(w io.Writer, t *template.Template) *templateFormatter {
+	newline := true
+	if t.Tree != nil && t.Tree.Root != nil {
+		newline = !strings.HasSuffix(t.Tree.Root.String(), "\n")
+	}
+	return &templateFormatter{w: bufio.NewWriter(w), t: t, newline: newline}
+}
+
+func (f *templateFormatter) WriteEvent(item *Event) error {
+	if err := f.t.Execute(f.w, newTemplateEvent(item)); err != nil {
+		return err
+	}
+	if f.newline {
+		return f.w.WriteByte('\n')
+	}
+	return nil
+}
+
+func (f *templateFormatter) Flush() error {
+	return f.w.Flush()
+}
+
+func (f *templateFormatter) Close() error {
+	return f.Flush()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplateFormat(t *testing.T) {
+	tmpl, err := parseTemplate(`{{.ID}}\t{{date "Jan 2 15:04" .Start}}\t{{duration .Start .End}}\t{{.Summary}}`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := format(t, "template", formatOptions{template: tmpl}, fixtureEvents())
+	got := lines(string(out))
+	if len(got) != 2 || got[0] != "m1\tMar 4 09:30\t1h30m0s\tPlanning, Q2; budget" {
+		t.Errorf("got %q", got)
+	}
+	if !strings.HasPrefix(got[1], "h1\tMar 8 00:00\t24h0m0s\tOffsite") {
+		t.Errorf("all-day event written as %q", got[1])
+	}
+}
+
+func TestTemplateKeepsFinalNewline(t *testing.T) {
+	tmpl, err := parseTemplate(`{{.ID}}\n`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := format(t, "template", formatOptions{template: tmpl}, fixtureEvents()); string(out) != "m1\nh1\n" {
+		t.Errorf("got %q, want one line per event", out)
+	}
+}
+
+func TestTemplateParseError(t *testing.T) {
+	_, err := parseTemplate("{{.ID}}\n{{.Summary", "")
+	if err == nil || !strings.Contains(err.Error(), "invalid template") || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("got error %v, want the position of the error on line 2", err)
+	}
+	if _, err := parseTemplate("", ""); err == nil {
+		t.Error("empty template was accepted")
+	}
+	if _, err := parseTemplate("x", "file.tmpl"); err == nil {
+		t.Error("--template with --template-file was accepted")
+	}
+}
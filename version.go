@@ -0,0 +1,22 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Build metadata, set with -ldflags "-X main.version=... -X main.commit=...
+// -X main.date=...".
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
+// errVersion is returned by parseFlags when --version is given.
+var errVersion = errors.New("version requested")
+
+// Returns the version line printed by --version.
+func versionString() string {
+	return fmt.Sprintf("calendar %s (commit %s, built %s)", version, commit, date)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// Subscribes a callback URL to changes in the events of a calendar and prints
+// the channel to stop it with.
+func watchEvents(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("calendar watch", flag.ContinueOnError)
+	var auth authFlags
+	var calendarID string
+	var callbackURL string
+	var channelID string
+	var channelToken string
+	var ttl time.Duration
+	auth.register(fs)
+	fs.StringVar(&calendarID, "calendar", "primary", "Calendar ID to watch the events of")
+	fs.StringVar(&callbackURL, "callback-url", "", "Public HTTPS URL receiving the change notifications")
+	fs.StringVar(&channelID, "id", "", "ID of the notification channel (default a random ID)")
+	fs.StringVar(&channelToken, "channel-token", "", "Secret sent back in the X-Goog-Channel-Token header of each notification")
+	fs.DurationVar(&ttl, "ttl", 0, "How long the channel lasts, 0 for the API's default of a week")
+	if err := parseFlags(fs, args, stderr); err != nil {
+		return err
+	}
+	ch, err := newChannel(callbackURL, channelID, channelToken, ttl)
+	if err != nil {
+		return err
+	}
+
+	srv, err := connect(ctx, &auth, fs, stderr, retryPolicy{})
+	if err != nil {
+		return err
+	}
+	created, err := srv.WatchEvents(ctx, calendarID, ch)
+	if isUnauthorized(err) {
+		return auth.rejected(err)
+	}
+	if isNotFound(err) {
+		return fmt.Errorf("calendar %q not found or not accessible", calendarID)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to watch events: %v", err)
+	}
+	fmt.Fprintf(stdout, "channel id: %s\nresource id: %s\n", created.Id, created.ResourceId)
+	if created.Expiration > 0 {
+		fmt.Fprintf(stdout, "expiration: %s\n", time.Unix(0, created.Expiration*int64(time.Millisecond)).Format(time.RFC3339))
+	}
+	infof("Stop the notifications with: calendar stop-watch --id %s --resource-id %s", created.Id, created.ResourceId)
+	return nil
+}
+
+// Builds the web hook channel delivering notifications to callbackURL, which
+// the API requires to use HTTPS. A channel ID is generated when id is empty,
+// and a zero ttl leaves the expiration to the API.
+func newChannel(callbackURL, id, token string, ttl time.Duration) (*calendar.Channel, error) {
+	if callbackURL == "" {
+		return nil, errors.New("--callback-url is required")
+	}
+	u, err := url.Parse(callbackURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("--callback-url %q must be an https URL", callbackURL)
+	}
+	if ttl < 0 {
+		return nil, errors.New("--ttl must not be negative")
+	}
+	if id == "" {
+		if id, err = randomState(); err != nil {
+			return nil, err
+		}
+	}
+	ch := &calendar.Channel{Id: id, Type: "web_hook", Address: callbackURL, Token: token}
+	if ttl > 0 {
+		ch.Params = map[string]string{"ttl": strconv.FormatInt(int64(ttl/time.Second), 10)}
+	}
+	return ch, nil
+}
+
+// Stops the notifications of a channel created by watch.
+func stopWatch(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("calendar stop-watch", flag.ContinueOnError)
+	var auth authFlags
+	var ch calendar.Channel
+	auth.register(fs)
+	fs.StringVar(&ch.Id, "id", "", "ID of the channel to stop, as printed by watch")
+	fs.StringVar(&ch.ResourceId, "resource-id", "", "ID of the watched resource, as printed by watch")
+	if err := parseFlags(fs, args, stderr); err != nil {
+		return err
+	}
+	if ch.Id == "" || ch.ResourceId == "" {
+		return errors.New("--id and --resource-id are required")
+	}
+
+	srv, err := connect(ctx, &auth, fs, stderr, retryPolicy{})
+	if err != nil {
+		return err
+	}
+	err = srv.StopChannel(ctx, &ch)
+	if isNotFound(err) {
+		infof("Channel %s was not found or has already stopped", ch.Id)
+		return nil
+	}
+	if isUnauthorized(err) {
+		return auth.rejected(err)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to stop channel: %v", err)
+	}
+	infof("Stopped channel %s", ch.Id)
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewChannelValidation(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		url  string
+		ttl  time.Duration
+		want string
+	}{
+		{"no URL", "", 0, "--callback-url is required"},
+		{"plain HTTP", "http://example.com/hook", 0, "must be an https URL"},
+		{"no host", "https:///hook", 0, "must be an https URL"},
+		{"negative ttl", "https://example.com/hook", -time.Hour, "--ttl must not be negative"},
+	} {
+		_, err := newChannel(c.url, "", "", c.ttl)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got error %v, want %q", c.name, err, c.want)
+		}
+	}
+}
+
+func TestWatchCommand(t *testing.T) {
+	srv := &fakeService{}
+	defer useService(srv)()
+	out, err := runCommand("watch", "--calendar", "team", "--callback-url", "https://example.com/hook",
+		"--id", "chan-1", "--channel-token", "secret", "--ttl", "24h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "channel id: chan-1\nresource id: resource-team\nexpiration: " +
+		time.Date(2024, 1, 22, 0, 0, 0, 0, time.UTC).Local().Format(time.RFC3339) + "\n"
+	if out != want {
+		t.Errorf("printed %q, want %q", out, want)
+	}
+	chans := srv.watched["team"]
+	if len(chans) != 1 {
+		t.Fatalf("created %d channels, want 1", len(chans))
+	}
+	ch := chans[0]
+	if ch.Type != "web_hook" || ch.Address != "https://example.com/hook" || ch.Token != "secret" || ch.Params["ttl"] != "86400" {
+		t.Errorf("created channel %+v", ch)
+	}
+}
+
+func TestWatchGeneratesChannelID(t *testing.T) {
+	srv := &fakeService{}
+	defer useService(srv)()
+	if _, err := runCommand("watch", "--callback-url", "https://example.com/hook"); err != nil {
+		t.Fatal(err)
+	}
+	if chans := srv.watched["primary"]; len(chans) != 1 || chans[0].Id == "" {
+		t.Errorf("created channels %v, want one with an ID", chans)
+	}
+}
+
+func TestStopWatchCommand(t *testing.T) {
+	srv := &fakeService{}
+	defer useService(srv)()
+	if _, err := runCommand("stop-watch", "--id", "chan-1"); err == nil || !strings.Contains(err.Error(), "--resource-id are required") {
+		t.Errorf("got error %v, want --resource-id required", err)
+	}
+	if _, err := runCommand("stop-watch", "--id", "chan-1", "--resource-id", "resource-team"); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.stopped) != 1 || srv.stopped[0].Id != "chan-1" || srv.stopped[0].ResourceId != "resource-team" {
+		t.Errorf("stopped %v, want chan-1 of resource-team", srv.stopped)
+	}
+}
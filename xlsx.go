@@ -0,0 +1,171 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Fields written as Excel date cells rather than text.
+var xlsxDateFields = map[string]bool{"start": true, "end": true, "originalStart": true}
+
+// Cell styles, by index into the cellXfs of xlsxStyles.
+const (
+	xlsxStyleHeader   = 1
+	xlsxStyleDateTime = 2
+	xlsxStyleDate     = 3
+)
+
+// xlsxFormatter writes events as an Excel workbook with a sheet holding a row
+// per event and a column per field, below a frozen header row. Start and end
+// times are date cells, in the offset the API gave them in, whose display
+// --date-format does not change. The rows are held until Close, which writes
+// the whole workbook.
+type xlsxFormatter struct {
+	w         io.Writer
+	fields    []field
+	fieldOpts fieldOptions
+	noHeader  bool
+	rows      bytes.Buffer
+	n         int
+}
+
+func newXLSXFormatter(w io.Writer, opts formatOptions) *xlsxFormatter {
+	f := &xlsxFormatter{w: w, fields: opts.fields, fieldOpts: opts.rfc3339(), noHeader: opts.noHeader}
+	if !f.noHeader {
+		f.row(fieldNames(f.fields), func(int, string) int { return xlsxStyleHeader })
+	}
+	return f
+}
+
+// Appends a row of values to the sheet, each in the style returned by style
+// for its column, which also writes dates as numbers.
+func (f *xlsxFormatter) row(values []string, style func(col int, v string) int) {
+	f.n++
+	r := strconv.Itoa(f.n)
+	f.rows.WriteString(`<row r="` + r + `">`)
+	for i, v := range values {
+		ref := xlsxColumn(i) + r
+		s := style(i, v)
+		if serial, ok := excelDate(v); ok && (s == xlsxStyleDateTime || s == xlsxStyleDate) {
+			f.rows.WriteString(`<c r="` + ref + `" s="` + strconv.Itoa(s) + `"><v>` + serial + `</v></c>`)
+			continue
+		}
+		f.rows.WriteString(`<c r="` + ref + `" t="inlineStr"`)
+		if s != 0 {
+			f.rows.WriteString(` s="` + strconv.Itoa(s) + `"`)
+		}
+		f.rows.WriteString(`><is><t xml:space="preserve">`)
+		xml.EscapeText(&f.rows, []byte(v))
+		f.rows.WriteString(`</t></is></c>`)
+	}
+	f.rows.WriteString("</row>")
+}
+
+func (f *xlsxFormatter) WriteEvent(item *Event) error {
+	f.row(fieldValues(f.fields, item, &f.fieldOpts), func(col int, v string) int {
+		if !xlsxDateFields[f.fields[col].name] {
+			return 0
+		}
+		if len(v) == len("2006-01-02") {
+			return xlsxStyleDate
+		}
+		return xlsxStyleDateTime
+	})
+	return nil
+}
+
+func (f *xlsxFormatter) Flush() error {
+	return nil
+}
+
+func (f *xlsxFormatter) Close() error {
+	z := zip.NewWriter(f.w)
+	pane := ""
+	if !f.noHeader {
+		pane = `<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`
+	}
+	parts := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles},
+		{"xl/worksheets/sheet1.xml", xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			pane + `<sheetData>` + f.rows.String() + `</sheetData></worksheet>`},
+	}
+	for _, p := range parts {
+		w, err := z.Create(p.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, p.content); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// Returns the letters naming column i, counting from zero: A to Z, then AA.
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// Converts an RFC3339 time or a date to an Excel date serial number, the days
+// since 1899-12-30, keeping the time of day in its own offset.
+func excelDate(v string) (string, bool) {
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		if t, err = time.Parse("2006-01-02", v); err != nil {
+			return "", false
+		}
+	}
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+	days := wall.Sub(time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)).Hours() / 24
+	return strconv.FormatFloat(days, 'f', -1, 64), true
+}
+
+// The fixed parts of the workbook.
+const (
+	xlsxContentTypes = xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`</Types>`
+	xlsxRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+	xlsxWorkbook = xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Events" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxWorkbookRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`
+	xlsxStyles = xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<numFmts count="2"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm"/><numFmt numFmtId="165" formatCode="yyyy-mm-dd"/></numFmts>` +
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="4">` +
+		`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+		`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`</cellXfs>` +
+		`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+		`</styleSheet>`
+)
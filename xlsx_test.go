@@ -0,0 +1,109 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A cell of a worksheet as written by xlsxFormatter.
+type xlsxCell struct {
+	Ref    string `xml:"r,attr"`
+	Style  string `xml:"s,attr"`
+	Type   string `xml:"t,attr"`
+	Value  string `xml:"v"`
+	Inline string `xml:"is>t"`
+}
+
+// Opens the workbook in data and returns the cells of its sheet by row.
+func readWorkbook(t *testing.T, data []byte) [][]xlsxCell {
+	t.Helper()
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sheet []byte
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Every part must be well formed for Excel to open the workbook.
+		if err := xml.Unmarshal(b, new(struct{})); err != nil {
+			t.Errorf("%s: %v", f.Name, err)
+		}
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			sheet = b
+		}
+	}
+	var ws struct {
+		Pane struct {
+			YSplit string `xml:"ySplit,attr"`
+			State  string `xml:"state,attr"`
+		} `xml:"sheetViews>sheetView>pane"`
+		Rows []struct {
+			Cells []xlsxCell `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := xml.Unmarshal(sheet, &ws); err != nil {
+		t.Fatal(err)
+	}
+	if ws.Pane.YSplit != "1" || ws.Pane.State != "frozen" {
+		t.Errorf("pane is %+v, want the header row frozen", ws.Pane)
+	}
+	rows := make([][]xlsxCell, len(ws.Rows))
+	for i, r := range ws.Rows {
+		rows[i] = r.Cells
+	}
+	return rows
+}
+
+func TestXLSXFormat(t *testing.T) {
+	fields, err := parseFields("start,end,summary,location")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := format(t, "xlsx", formatOptions{fields: fields}, fixtureEvents())
+	rows := readWorkbook(t, out)
+	text := func(v string) xlsxCell { return xlsxCell{Type: "inlineStr", Inline: v} }
+	date := func(style, v string) xlsxCell { return xlsxCell{Style: style, Value: v} }
+	header := func(v string) xlsxCell { return xlsxCell{Type: "inlineStr", Style: "1", Inline: v} }
+	want := [][]xlsxCell{
+		{header("start"), header("end"), header("summary"), header("location")},
+		// 2024-03-04 is day 45355 since 1899-12-30.
+		{date("2", "45355.395833333336"), date("2", "45355.458333333336"), text("Planning, Q2; budget"), text("Room 4, Building B")},
+		{date("3", "45359"), date("3", "45359"), text("Offsite"), text("")},
+	}
+	for _, r := range rows {
+		for i := range r {
+			r[i].Ref = ""
+		}
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got rows\n%+v\nwant\n%+v", rows, want)
+	}
+}
+
+func TestXLSXColumn(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		if got := xlsxColumn(i); got != want {
+			t.Errorf("xlsxColumn(%d) = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestXLSXRequiresOutput(t *testing.T) {
+	_, err := runCommand("--start", "2024-01-01", "--end", "2024-01-31", "--format", "xlsx")
+	if err == nil || !strings.Contains(err.Error(), "requires --output") {
+		t.Errorf("got error %v, want --output required", err)
+	}
+}